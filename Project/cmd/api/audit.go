@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+
+	"gourmetspices.yerassyl.net/internal/data"
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+// listAuditEventsHandler lists the audit trail, newest first unless another order is
+// asked for. It can be narrowed down to one actor, action, entity type or entity, and
+// to a time range given as RFC 3339 timestamps in since and until.
+func (app *application) listAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		data.AuditEventFilter
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.ActorID = int64(app.readInt(qs, "actor", 0, v))
+	input.Action = app.readString(qs, "action", "")
+	input.EntityType = app.readString(qs, "entity_type", "")
+	input.EntityID = int64(app.readInt(qs, "entity_id", 0, v))
+	input.Since = app.readTime(qs, "since", v)
+	input.Until = app.readTime(qs, "until", v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+
+	v.Check(input.ActorID >= 0, "actor", "must be a valid user id")
+	v.Check(input.EntityID >= 0, "entity_id", "must be a valid id")
+	if input.Action != "" {
+		v.Check(validator.In(input.Action, data.AuditActions...), "action", "must be one of create, update, delete, restore, purge")
+	}
+	if input.EntityType != "" {
+		v.Check(validator.In(input.EntityType, data.AuditEntityTypes...), "entity_type", "must be one of herb, user")
+	}
+	if !input.Since.IsZero() && !input.Until.IsZero() {
+		v.Check(input.Since.Before(input.Until), "until", "must be after since")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	events, metadata, err := app.models.AuditEvents.GetAll(input.AuditEventFilter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"audit_events": events, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
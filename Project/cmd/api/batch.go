@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"gourmetspices.yerassyl.net/internal/data"
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+// maxBatchOperations caps the number of operations in one herb batch.
+const maxBatchOperations = 500
+
+// batchOperation is one operation in the body of a herb batch. The herb fields are
+// pointers so that updates only change the fields they give, as with PATCH.
+type batchOperation struct {
+	Op      string `json:"op"`
+	ID      int64  `json:"id"`
+	Version int32  `json:"version"`
+	Herb    struct {
+		Name         *string     `json:"name"`
+		Description  *string     `json:"description"`
+		Price        *data.Price `json:"price"`
+		CulinaryUses []string    `json:"culinary_uses"`
+		StockUnit    *string     `json:"stock_unit"`
+	} `json:"herb"`
+}
+
+// batchResult is the outcome of one operation. Status is the HTTP status the operation
+// would have had as a request of its own, and Error holds either a message or, for
+// validation failures, the same field errors as a failed validation response. Operations
+// which were rolled back because another one failed get 424 Failed Dependency.
+type batchResult struct {
+	Index  int         `json:"index"`
+	Op     string      `json:"op"`
+	ID     int64       `json:"id,omitempty"`
+	Status int         `json:"status"`
+	Herb   *data.Herb  `json:"herb,omitempty"`
+	Error  interface{} `json:"error,omitempty"`
+}
+
+type batchReport struct {
+	Mode      string        `json:"mode"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Results   []batchResult `json:"results"`
+}
+
+// batchHerbsHandler runs a batch of herb creates, updates and deletes. Updates and
+// deletes must give the version of the herb they expect, as with If-Match. In atomic
+// mode, the default, either every operation succeeds or none do, and the response has
+// the status of the operation which failed. In best_effort mode each operation stands
+// alone and the response is always 200 OK. Either way there is a result for every
+// operation.
+func (app *application) batchHerbsHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Mode       string           `json:"mode"`
+		Operations []batchOperation `json:"operations"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Mode == "" {
+		input.Mode = "atomic"
+	}
+
+	v := validator.New()
+
+	v.Check(validator.In(input.Mode, "atomic", "best_effort"), "mode", "must be one of atomic, best_effort")
+	v.Check(len(input.Operations) >= 1, "operations", "must contain at least 1 operation")
+	v.Check(len(input.Operations) <= maxBatchOperations, "operations", "must not contain more than 500 operations")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	atomic := input.Mode == "atomic"
+
+	report := batchReport{Mode: input.Mode, Results: make([]batchResult, len(input.Operations))}
+
+	// Check every operation before running any of them, so that an atomic batch with a
+	// bad operation is turned away without touching the database.
+	ops := []*data.HerbOperation{}
+	indexes := []int{}
+
+	for i := range input.Operations {
+		item := &input.Operations[i]
+
+		report.Results[i] = batchResult{Index: i, Op: item.Op, ID: item.ID}
+
+		op, status, message, err := app.prepareBatchOperation(item)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if op == nil {
+			report.Results[i].Status = status
+			report.Results[i].Error = message
+			continue
+		}
+
+		ops = append(ops, op)
+		indexes = append(indexes, i)
+	}
+
+	failed := len(ops) < len(input.Operations)
+
+	if !failed || !atomic {
+		errs, err := app.models.Herbs.Batch(ops, atomic, app.actor(r))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		for j, op := range ops {
+			result := &report.Results[indexes[j]]
+
+			switch {
+			case errs[j] == nil:
+				result.Status = http.StatusOK
+				if op.Op == "create" {
+					result.Status = http.StatusCreated
+				}
+				if op.Herb != nil {
+					result.ID = op.Herb.ID
+					result.Herb = op.Herb
+				}
+			case errors.Is(errs[j], data.ErrRecordNotFound):
+				result.Status = http.StatusNotFound
+				result.Error = "the requested resource could not be found"
+				failed = true
+			case errors.Is(errs[j], data.ErrEditConflict):
+				result.Status = http.StatusConflict
+				result.Error = "the herb has changed since the given version"
+				failed = true
+			default:
+				// Only best_effort batches get here, as atomic ones stop at the error.
+				app.logError(r, errs[j])
+				result.Status = http.StatusInternalServerError
+				result.Error = "the server encountered a problem and could not process your request"
+				failed = true
+			}
+		}
+	}
+
+	status := http.StatusOK
+
+	for i := range report.Results {
+		result := &report.Results[i]
+
+		if atomic && failed {
+			if result.Error == nil {
+				result.Status = http.StatusFailedDependency
+				result.Error = "not applied because another operation in the batch failed"
+				result.Herb = nil
+			} else if status == http.StatusOK {
+				status = result.Status
+			}
+		}
+
+		if result.Error == nil {
+			report.Succeeded++
+		} else {
+			report.Failed++
+		}
+	}
+
+	if status != http.StatusOK {
+		app.errorResponse(w, r, status, report)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"batch": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// prepareBatchOperation checks one operation of a batch and turns it into a
+// data.HerbOperation. If the operation can't be run it returns nil together with the
+// status and error to report for it. The error return is for unexpected failures,
+// which stop the whole batch.
+func (app *application) prepareBatchOperation(item *batchOperation) (*data.HerbOperation, int, interface{}, error) {
+	v := validator.New()
+
+	v.Check(validator.In(item.Op, data.HerbOperations...), "op", "must be one of create, update, delete")
+	if item.Op == "update" || item.Op == "delete" {
+		v.Check(item.ID > 0, "id", "must be provided")
+		v.Check(item.Version > 0, "version", "must be provided")
+	}
+
+	if !v.Valid() {
+		return nil, http.StatusUnprocessableEntity, v.Errors, nil
+	}
+
+	if item.Op == "delete" {
+		return &data.HerbOperation{Op: item.Op, ID: item.ID, Version: item.Version}, 0, nil, nil
+	}
+
+	herb := &data.Herb{StockUnit: "grams"}
+
+	if item.Op == "update" {
+		var err error
+
+		herb, err = app.models.Herbs.Get(item.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				return nil, http.StatusNotFound, "the requested resource could not be found", nil
+			default:
+				return nil, 0, nil, err
+			}
+		}
+
+		if herb.Version != item.Version {
+			return nil, http.StatusConflict, "the herb has changed since the given version", nil
+		}
+	}
+
+	if item.Herb.Name != nil {
+		herb.Name = *item.Herb.Name
+	}
+	if item.Herb.Description != nil {
+		herb.Description = *item.Herb.Description
+	}
+	if item.Herb.Price != nil {
+		herb.Price = *item.Herb.Price
+	}
+	if item.Herb.CulinaryUses != nil {
+		herb.CulinaryUses = item.Herb.CulinaryUses
+	}
+	if item.Herb.StockUnit != nil {
+		herb.StockUnit = *item.Herb.StockUnit
+	}
+
+	if data.ValidateHerb(v, herb); !v.Valid() {
+		return nil, http.StatusUnprocessableEntity, v.Errors, nil
+	}
+
+	return &data.HerbOperation{Op: item.Op, Herb: herb, ID: herb.ID, Version: herb.Version}, 0, nil, nil
+}
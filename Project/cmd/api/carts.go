@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"gourmetspices.yerassyl.net/internal/data"
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+// showCartHandler returns the authenticated user's cart, totalled in the requested
+// currency or the default currency.
+func (app *application) showCartHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	currency := app.readCurrency(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if currency == "" {
+		currency = data.DefaultCurrency
+	}
+
+	cart, err := app.models.Carts.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	converter, err := app.models.ExchangeRates.Converter(currency)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = cart.Total(converter)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrExchangeRateNotFound):
+			v.AddError("currency", "has no exchange rate for every price in the cart")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Add("Vary", "Accept-Currency")
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"cart": cart}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// setCartItemHandler puts the herb identified by the :id URL parameter in the user's
+// cart with the given quantity, replacing any quantity already there.
+func (app *application) setCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	herbID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Quantity int64 `json:"quantity"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateCartQuantity(v, input.Quantity); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Carts.SetItem(app.contextGetUser(r).ID, herbID, input.Quantity)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownHerb):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "cart successfully updated"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	herbID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Carts.RemoveItem(app.contextGetUser(r).ID, herbID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "item successfully removed from cart"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gourmetspices.yerassyl.net/internal/data"
+)
+
+// herbETag returns the strong ETag of a response carrying herb, whose serialized body
+// is js. The body depends on more than the herb's own fields, such as its reviews,
+// images and sales and the currency and fields asked for, so the ETag is made of the
+// herb's version and a hash of the body. Only the version counts for If-Match.
+func herbETag(herb *data.Herb, js []byte) string {
+	sum := sha256.Sum256(js)
+	return fmt.Sprintf(`"%d-%s"`, herb.Version, hex.EncodeToString(sum[:8]))
+}
+
+// herbETagVersion returns the herb version in a strong ETag made by herbETag.
+func herbETagVersion(etag string) (int32, bool) {
+	if !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) || len(etag) < 2 {
+		return 0, false
+	}
+
+	version, _, _ := strings.Cut(etag[1:len(etag)-1], "-")
+
+	n, err := strconv.ParseInt(version, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+
+	return int32(n), true
+}
+
+// etagMatches reports whether an If-None-Match header value, which is either "*" or a
+// list of ETags, matches etag. It uses weak comparison, which ignores the W/ prefix.
+func etagMatches(header, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+
+		if candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The checkIfMatch() helper checks the If-Match header of a request to change herb. It
+// returns true if the request can go ahead, which it always can if there is no
+// If-Match header, and otherwise only if the header has "*" or the ETag of any
+// response for the herb's current version. If not it sends a 412 Precondition Failed
+// response and returns false.
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, herb *data.Herb) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" || strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		version, ok := herbETagVersion(strings.TrimSpace(candidate))
+		if ok && version == herb.Version {
+			return true
+		}
+	}
+
+	app.preconditionFailedResponse(w, r)
+	return false
+}
+
+// The notModified() helper sets the ETag header and, if the request is a GET or HEAD
+// whose If-None-Match header matches it, sends a 304 Not Modified response and returns
+// true.
+func (app *application) notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if !etagMatches(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// writeJSONWithETag is writeJSON for responses which can be revalidated. The ETag is
+// made by passing the serialized body to etag, so that it changes whenever the body
+// does, and GET requests with a matching If-None-Match header get a 304 Not Modified
+// instead of the body.
+func (app *application) writeJSONWithETag(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header, etag func(js []byte) string) error {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return err
+	}
+	js = append(js, '\n')
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	if app.notModified(w, r, etag(js)) {
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+	return nil
+}
+
+// writeJSONWithWeakETag is writeJSON for responses such as listings, which are built
+// from many records and have no version of their own. The response gets a weak ETag
+// made from a hash of its body, so that clients can still revalidate it with
+// If-None-Match and get a 304 Not Modified if nothing has changed.
+func (app *application) writeJSONWithWeakETag(w http.ResponseWriter, r *http.Request, status int, data envelope) error {
+	return app.writeJSONWithETag(w, r, status, data, nil, func(js []byte) string {
+		sum := sha256.Sum256(js)
+		return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	})
+}
+
+// herbETagFunc returns the etag function to pass to writeJSONWithETag for a response
+// carrying herb.
+func herbETagFunc(herb *data.Herb) func(js []byte) string {
+	return func(js []byte) string {
+		return herbETag(herb, js)
+	}
+}
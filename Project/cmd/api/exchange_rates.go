@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"gourmetspices.yerassyl.net/internal/data"
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+func (app *application) listExchangeRatesHandler(w http.ResponseWriter, r *http.Request) {
+	rates, err := app.models.ExchangeRates.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"exchange_rates": rates}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateExchangeRatesHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Rates []*data.ExchangeRate `json:"rates"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Rates) >= 1, "rates", "must contain at least 1 rate")
+	for i, rate := range input.Rates {
+		key := fmt.Sprintf("rates[%d]", i)
+		if rate == nil {
+			v.AddError(key, "must be provided")
+			continue
+		}
+		data.ValidateExchangeRate(v, key, rate)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.ExchangeRates.Upsert(input.Rates)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"exchange_rates": input.Rates}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gourmetspices.yerassyl.net/internal/data"
+	"gourmetspices.yerassyl.net/internal/validator"
+	"gourmetspices.yerassyl.net/internal/xlsx"
+)
+
+// herbExportColumns are the columns of CSV and XLSX exports. Prices use the same
+// "12.50 USD" format as the JSON representation, and culinary uses are separated by
+// semicolons, as in imports.
+var herbExportColumns = []string{"id", "name", "description", "price", "culinary_uses", "stock_quantity", "stock_unit", "average_rating", "review_count", "version"}
+
+// herbExportWriter writes herbs to an export file. Close finishes the file.
+type herbExportWriter interface {
+	Write(herb *data.Herb) error
+	Close() error
+}
+
+// exportHerbsHandler streams every herb matching the same name, culinary_uses and
+// category filters as listHerbsHandler, in CSV, NDJSON or XLSX. The herbs are read
+// through a database cursor and written out as they arrive, so the export is never held
+// in memory. Once the first bytes have been sent there is no way to report an error
+// to the client, so the connection is aborted instead.
+func (app *application) exportHerbsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	format := app.readString(qs, "format", "csv")
+	filter := app.readHerbFilter(qs, v)
+
+	v.Check(validator.In(format, "csv", "ndjson", "xlsx"), "format", "must be one of csv, ndjson, xlsx")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	cursor, err := app.models.Herbs.Export(r.Context(), filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer cursor.Close()
+
+	// An export can take longer than the server's write timeout. The request context
+	// still ends it if the client goes away.
+	err = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var writer herbExportWriter
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		writer, err = newCSVHerbExport(w)
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		writer, err = newNDJSONHerbExport(w)
+	case "xlsx":
+		w.Header().Set("Content-Type", xlsx.ContentType)
+		writer, err = newXLSXHerbExport(w)
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="herbs.`+format+`"`)
+
+	if err == nil {
+		err = writeHerbExport(cursor, writer)
+	}
+	if err != nil {
+		app.logError(r, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// writeHerbExport writes every herb from the cursor and finishes the file.
+func writeHerbExport(cursor *data.HerbCursor, writer herbExportWriter) error {
+	for {
+		herb, err := cursor.Next()
+		if err != nil {
+			return err
+		}
+		if herb == nil {
+			break
+		}
+
+		err = writer.Write(herb)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writer.Close()
+}
+
+type csvHerbExport struct {
+	w *csv.Writer
+}
+
+func newCSVHerbExport(w io.Writer) (*csvHerbExport, error) {
+	e := &csvHerbExport{w: csv.NewWriter(w)}
+	return e, e.w.Write(herbExportColumns)
+}
+
+func (e *csvHerbExport) Write(herb *data.Herb) error {
+	return e.w.Write([]string{
+		strconv.FormatInt(herb.ID, 10),
+		herb.Name,
+		herb.Description,
+		herb.Price.String(),
+		strings.Join(herb.CulinaryUses, ";"),
+		strconv.FormatInt(herb.StockQuantity, 10),
+		herb.StockUnit,
+		strconv.FormatFloat(herb.AverageRating, 'f', -1, 64),
+		strconv.FormatInt(int64(herb.ReviewCount), 10),
+		strconv.FormatInt(int64(herb.Version), 10),
+	})
+}
+
+func (e *csvHerbExport) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonHerbExport struct {
+	enc *json.Encoder
+}
+
+func newNDJSONHerbExport(w io.Writer) (*ndjsonHerbExport, error) {
+	return &ndjsonHerbExport{enc: json.NewEncoder(w)}, nil
+}
+
+func (e *ndjsonHerbExport) Write(herb *data.Herb) error {
+	return e.enc.Encode(herb)
+}
+
+func (e *ndjsonHerbExport) Close() error {
+	return nil
+}
+
+type xlsxHerbExport struct {
+	w *xlsx.Writer
+}
+
+func newXLSXHerbExport(w io.Writer) (*xlsxHerbExport, error) {
+	xw, err := xlsx.NewWriter(w, "Herbs")
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]interface{}, len(herbExportColumns))
+	for i, column := range herbExportColumns {
+		header[i] = column
+	}
+
+	return &xlsxHerbExport{w: xw}, xw.WriteRow(header...)
+}
+
+func (e *xlsxHerbExport) Write(herb *data.Herb) error {
+	return e.w.WriteRow(
+		herb.ID,
+		herb.Name,
+		herb.Description,
+		herb.Price.String(),
+		strings.Join(herb.CulinaryUses, ";"),
+		herb.StockQuantity,
+		herb.StockUnit,
+		herb.AverageRating,
+		herb.ReviewCount,
+		herb.Version,
+	)
+}
+
+func (e *xlsxHerbExport) Close() error {
+	return e.w.Close()
+}
@@ -4,21 +4,30 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"gourmetspices.yerassyl.net/internal/data"
 	"gourmetspices.yerassyl.net/internal/validator"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readInt64Param(r, "id")
+}
+
+// The readInt64Param() helper reads a positive integer URL parameter with the given
+// name, for routes such as /v1/herbs/:id/variants/:variant_id which carry more than one.
+func (app *application) readInt64Param(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 	return id, nil
 }
@@ -149,6 +158,57 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// The readTime() helper reads an RFC 3339 timestamp from the query string. If no
+// matching key could be found it returns the zero time, and if the value isn't a valid
+// timestamp then we record an error message in the provided Validator instance.
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp such as 2024-01-31T09:00:00Z")
+		return time.Time{}
+	}
+
+	return t
+}
+
+// The readCurrency() helper returns the currency the client wants prices returned in,
+// taken from the "currency" query string parameter or, failing that, the
+// Accept-Currency header. If neither is present it returns the empty string, and if
+// the currency isn't one we support then we record an error in the provided Validator.
+func (app *application) readCurrency(r *http.Request, v *validator.Validator) string {
+	currency := app.readString(r.URL.Query(), "currency", r.Header.Get("Accept-Currency"))
+	if currency == "" {
+		return ""
+	}
+
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if !data.SupportedCurrency(currency) {
+		v.AddError("currency", "must be a supported currency")
+		return ""
+	}
+
+	return currency
+}
+
+// actor returns who is making the request, for the audit trail.
+func (app *application) actor(r *http.Request) data.Actor {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	return data.Actor{
+		UserID:    app.contextGetUser(r).ID,
+		RequestID: app.contextGetRequestID(r),
+		IP:        ip,
+	}
+}
+
 func (app *application) background(fn func()) {
 	// Increment the WaitGroup counter.
 	app.wg.Add(1)
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"gourmetspices.yerassyl.net/internal/data"
 	"gourmetspices.yerassyl.net/internal/validator"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
 func (app *application) createHerbHandler(w http.ResponseWriter, r *http.Request) {
@@ -15,6 +18,7 @@ func (app *application) createHerbHandler(w http.ResponseWriter, r *http.Request
 		Description  string     `json:"description"`
 		Price        data.Price `json:"price"`
 		CulinaryUses []string   `json:"culinary_uses"`
+		StockUnit    string     `json:"stock_unit"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -28,6 +32,11 @@ func (app *application) createHerbHandler(w http.ResponseWriter, r *http.Request
 		Description:  input.Description,
 		Price:        input.Price,
 		CulinaryUses: input.CulinaryUses,
+		StockUnit:    input.StockUnit,
+	}
+
+	if herb.StockUnit == "" {
+		herb.StockUnit = "grams"
 	}
 
 	v := validator.New()
@@ -37,7 +46,7 @@ func (app *application) createHerbHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.models.Herbs.Insert(herb)
+	err = app.models.Herbs.Insert(herb, app.actor(r))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -46,7 +55,7 @@ func (app *application) createHerbHandler(w http.ResponseWriter, r *http.Request
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/herbs/%d", herb.ID))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"herb": herb}, headers)
+	err = app.writeJSONWithETag(w, r, http.StatusCreated, envelope{"herb": herb}, headers, herbETagFunc(herb))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -59,6 +68,18 @@ func (app *application) showHerbHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	currency := app.readCurrency(r, v)
+	fields := app.readFields(qs, v)
+	include := app.readInclude(qs, fields, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	herb, err := app.models.Herbs.Get(id)
 	if err != nil {
 		switch {
@@ -70,56 +91,96 @@ func (app *application) showHerbHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"herb": herb}, nil)
+	w.Header().Add("Vary", "Accept-Currency")
+
+	err = app.embedHerbRelations(include, herb)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-}
-
-func (app *application) updateHerbHandler(w http.ResponseWriter, r *http.Request) {
-
-	id, err := app.readIDParam(r)
-	if err != nil {
-		app.notFoundResponse(w, r)
-		return
+	if wantsField(fields, "sale_price") {
+		err = app.models.Promotions.ApplySales(herb)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 	}
 
-	herb, err := app.models.Herbs.Get(id)
+	err = app.convertHerbPrices(currency, herb)
 	if err != nil {
 		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrExchangeRateNotFound):
+			v.AddError("currency", "no exchange rate is available for this currency")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	var input struct {
-		Name         *string     `json:"name"`
-		Description  *string     `json:"description"`
-		Price        *data.Price `json:"price"`
-		CulinaryUses []string    `json:"culinary_uses"`
-	}
-
-	err = app.readJSON(w, r, &input)
+	sparse, err := sparseHerbs(fields, include, herb)
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	if input.Name != nil {
-		herb.Name = *input.Name
+	err = app.writeJSONWithETag(w, r, http.StatusOK, envelope{"herb": sparse[0]}, nil, herbETagFunc(herb))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 	}
-	if input.Description != nil {
-		herb.Description = *input.Description
+
+}
+
+// updateHerbHandler applies a partial update to a herb. With an If-Match header the
+// update only goes ahead if the herb is still at the version the client last fetched.
+func (app *application) updateHerbHandler(w http.ResponseWriter, r *http.Request) {
+
+	herb, ok := app.readHerb(w, r)
+	if !ok {
+		return
 	}
-	if input.Price != nil {
-		herb.Price = *input.Price
+
+	if !app.checkIfMatch(w, r, herb) {
+		return
 	}
-	if input.CulinaryUses != nil {
-		herb.CulinaryUses = input.CulinaryUses
+
+	// Merge Patches and JSON Patches can clear fields and edit lists in place. Any other
+	// body is plain JSON, where only the fields given are changed.
+	if mediaType := patchMediaType(r); mediaType != "" {
+		if !app.patchHerb(w, r, herb, mediaType) {
+			return
+		}
+	} else {
+		var input struct {
+			Name         *string     `json:"name"`
+			Description  *string     `json:"description"`
+			Price        *data.Price `json:"price"`
+			CulinaryUses []string    `json:"culinary_uses"`
+			StockUnit    *string     `json:"stock_unit"`
+		}
+
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		if input.Name != nil {
+			herb.Name = *input.Name
+		}
+		if input.Description != nil {
+			herb.Description = *input.Description
+		}
+		if input.Price != nil {
+			herb.Price = *input.Price
+		}
+		if input.CulinaryUses != nil {
+			herb.CulinaryUses = input.CulinaryUses
+		}
+		if input.StockUnit != nil {
+			herb.StockUnit = *input.StockUnit
+		}
 	}
 
 	v := validator.New()
@@ -128,9 +189,11 @@ func (app *application) updateHerbHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.models.Herbs.Update(herb)
+	err := app.models.Herbs.Update(herb, app.actor(r))
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
@@ -139,21 +202,60 @@ func (app *application) updateHerbHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"herb": herb}, nil)
+	err = app.writeJSONWithETag(w, r, http.StatusOK, envelope{"herb": herb}, nil, herbETagFunc(herb))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// deleteHerbHandler moves a herb to the trash. With an If-Match header the herb is only
+// deleted if it is still at the version the client last fetched.
 func (app *application) deleteHerbHandler(w http.ResponseWriter, r *http.Request) {
 
+	herb, ok := app.readHerb(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.checkIfMatch(w, r, herb) {
+		return
+	}
+
+	// Without If-Match the herb is deleted whatever its version.
+	var version int32
+	if r.Header.Get("If-Match") != "" {
+		version = herb.Version
+	}
+
+	err := app.models.Herbs.Delete(herb.ID, version, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "herb successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// restoreHerbHandler takes a deleted herb back out of the trash.
+func (app *application) restoreHerbHandler(w http.ResponseWriter, r *http.Request) {
+
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
 		return
 	}
 
-	err = app.models.Herbs.Delete(id)
+	herb, err := app.models.Herbs.Restore(id, app.actor(r))
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -164,7 +266,41 @@ func (app *application) deleteHerbHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "herb successfully deleted"}, nil)
+	err = app.writeJSONWithETag(w, r, http.StatusOK, envelope{"herb": herb}, nil, herbETagFunc(herb))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listDeletedHerbsHandler lists the herbs in the trash, most recently deleted first
+// unless another order is asked for.
+func (app *application) listDeletedHerbsHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	input.Filters.SortSafelist = []string{"id", "name", "deleted_at", "-id", "-name", "-deleted_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	herbs, metadata, err := app.models.Herbs.GetAllDeleted(input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONWithWeakETag(w, r, http.StatusOK, envelope{"herbs": herbs, "metadata": metadata})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -173,8 +309,11 @@ func (app *application) deleteHerbHandler(w http.ResponseWriter, r *http.Request
 func (app *application) listHerbsHandler(w http.ResponseWriter, r *http.Request) {
 
 	var input struct {
-		Name         string
-		CulinaryUses []string
+		data.HerbFilter
+		Currency string
+		Fields   []string
+		Include  []string
+		Facets   []string
 		data.Filters
 	}
 
@@ -182,26 +321,294 @@ func (app *application) listHerbsHandler(w http.ResponseWriter, r *http.Request)
 
 	qs := r.URL.Query()
 
-	input.Name = app.readString(qs, "name", "")
-	input.CulinaryUses = app.readCSV(qs, "culinary_uses", []string{})
+	input.HerbFilter = app.readHerbFilter(qs, v)
+
+	// Searches are ordered by relevance unless the client asks for something else.
+	defaultSort := "id"
+	if input.Search != "" {
+		defaultSort = "relevance"
+	}
+
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
-	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "name", "description", "price", "-id", "-name", "-description", "-price"}
+	input.Filters.Sort = app.readString(qs, "sort", defaultSort)
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	input.Filters.SortSafelist = []string{"id", "name", "description", "price", "rating", "relevance", "-id", "-name", "-description", "-price", "-rating"}
+	input.Currency = app.readCurrency(r, v)
+	input.Fields = app.readFields(qs, v)
+	input.Include = app.readInclude(qs, input.Fields, v)
+	input.Facets = app.readCSV(qs, "facets", []string{})
+
+	v.Check(validator.PermittedValues(input.Facets, data.HerbFacets...), "facets", "contains an unknown facet")
+
+	if input.Filters.Sort == "relevance" {
+		v.Check(input.Search != "", "sort", "relevance can only be used with search")
+	}
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	herbs, metadata, err := app.models.Herbs.GetAll(input.Name, input.CulinaryUses, input.Filters)
+	herbs, metadata, err := app.models.Herbs.GetAll(input.HerbFilter, input.Fields, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"metadata": metadata}
+
+	if len(input.Facets) > 0 {
+		facets, err := app.models.Herbs.Facets(input.HerbFilter, input.Facets)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrExchangeRateNotFound):
+				v.AddError("facets", "price_range needs an exchange rate for every herb's currency")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		env["facets"] = facets
+	}
+
+	err = app.embedHerbRelations(input.Include, herbs...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if wantsField(input.Fields, "sale_price") {
+		err = app.models.Promotions.ApplySales(herbs...)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.convertHerbPrices(input.Currency, herbs...)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrExchangeRateNotFound):
+			v.AddError("currency", "no exchange rate is available for this currency")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env["herbs"], err = sparseHerbs(input.Fields, input.Include, herbs...)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"herbs": herbs, "metadata": metadata}, nil)
+	w.Header().Add("Vary", "Accept-Currency")
+
+	err = app.writeJSONWithWeakETag(w, r, http.StatusOK, env)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// The readHerbFilter() helper reads the name, culinary_uses, category and search
+// parameters which narrow down herb listings and exports.
+func (app *application) readHerbFilter(qs url.Values, v *validator.Validator) data.HerbFilter {
+	filter := data.HerbFilter{
+		Name:         app.readString(qs, "name", ""),
+		CulinaryUses: app.readCSV(qs, "culinary_uses", []string{}),
+		CategoryID:   int64(app.readInt(qs, "category", 0, v)),
+		Search:       strings.TrimSpace(app.readString(qs, "search", "")),
+	}
+
+	v.Check(filter.CategoryID >= 0, "category", "must be a valid category id")
+	v.Check(len(filter.Search) <= 200, "search", "must not be more than 200 bytes long")
+
+	return filter
+}
+
+// convertHerbPrices rewrites the prices of the given herbs in the requested currency,
+// using the current exchange rates. It does nothing if no currency was requested.
+func (app *application) convertHerbPrices(currency string, herbs ...*data.Herb) error {
+	if currency == "" {
+		return nil
+	}
+
+	converter, err := app.models.ExchangeRates.Converter(currency)
+	if err != nil {
+		return err
+	}
+
+	for _, herb := range herbs {
+		// The price is left out of herbs read with a fieldset which doesn't need it.
+		if herb.Price.Currency != "" {
+			herb.Price, err = converter.Convert(herb.Price)
+			if err != nil {
+				return err
+			}
+		}
+
+		if herb.SalePrice != nil {
+			salePrice, err := converter.Convert(*herb.SalePrice)
+			if err != nil {
+				return err
+			}
+			herb.SalePrice = &salePrice
+		}
+
+		for _, variant := range herb.Variants {
+			variant.Price, err = converter.Convert(variant.Price)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// herbIncludeSafelist holds the related resources which can be embedded in herb
+// responses with the "include" query string parameter.
+var herbIncludeSafelist = []string{"variants", "categories", "images"}
+
+// The readFields() helper reads the "fields" query string parameter, which limits herb
+// responses to the listed fields, recording an error in the provided Validator for any
+// we don't know. An empty fieldset means every field.
+func (app *application) readFields(qs url.Values, v *validator.Validator) []string {
+	fields := app.readCSV(qs, "fields", []string{})
+	v.Check(validator.PermittedValues(fields, data.HerbFields...), "fields", "contains an unknown field")
+	return fields
+}
+
+// The readInclude() helper reads the related resources the client asked to have embedded
+// in herb responses, recording an error in the provided Validator for any we don't know.
+// Images are embedded unless the client asks for a fieldset without including them.
+func (app *application) readInclude(qs url.Values, fields []string, v *validator.Validator) []string {
+	include := app.readCSV(qs, "include", []string{})
+	v.Check(validator.PermittedValues(include, herbIncludeSafelist...), "include", "contains an unknown related resource")
+
+	if len(fields) == 0 && !validator.In("images", include...) {
+		include = append(include, "images")
+	}
+
+	return include
+}
+
+// wantsField reports whether a response with the given fieldset has the named field.
+func wantsField(fields []string, field string) bool {
+	return len(fields) == 0 || validator.In(field, fields...)
+}
+
+// sparseHerbs prepares herbs to be written out with only the fields in the fieldset and
+// the related resources in include. The ID is always kept, so that clients can still
+// tell herbs apart. With no fieldset the herbs are returned as they are.
+func sparseHerbs(fields, include []string, herbs ...*data.Herb) ([]interface{}, error) {
+	sparse := make([]interface{}, len(herbs))
+
+	for i, herb := range herbs {
+		if len(fields) == 0 {
+			sparse[i] = herb
+			continue
+		}
+
+		js, err := json.Marshal(herb)
+		if err != nil {
+			return nil, err
+		}
+
+		var all map[string]json.RawMessage
+
+		err = json.Unmarshal(js, &all)
+		if err != nil {
+			return nil, err
+		}
+
+		kept := map[string]json.RawMessage{"id": all["id"]}
+		for _, keys := range [][]string{fields, include} {
+			for _, key := range keys {
+				if value, ok := all[key]; ok {
+					kept[key] = value
+				}
+			}
+		}
+
+		sparse[i] = kept
+	}
+
+	return sparse, nil
+}
+
+// embedHerbRelations loads the related resources named in include and attaches them to
+// the given herbs, using one query per resource type rather than one per herb.
+func (app *application) embedHerbRelations(include []string, herbs ...*data.Herb) error {
+	if len(herbs) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(herbs))
+	for i, herb := range herbs {
+		ids[i] = herb.ID
+	}
+
+	if validator.In("variants", include...) {
+		variants, err := app.models.HerbVariants.GetAllForHerbs(ids)
+		if err != nil {
+			return err
+		}
+
+		for _, herb := range herbs {
+			herb.Variants = variants[herb.ID]
+		}
+	}
+
+	if validator.In("categories", include...) {
+		categories, err := app.models.Categories.GetAllForHerbs(ids)
+		if err != nil {
+			return err
+		}
+
+		for _, herb := range herbs {
+			herb.Categories = categories[herb.ID]
+		}
+	}
+
+	if validator.In("images", include...) {
+		images, err := app.models.HerbImages.GetAllForHerbs(ids)
+		if err != nil {
+			return err
+		}
+
+		for _, herb := range herbs {
+			herb.Images = images[herb.ID]
+			app.setImageURLs(herb.Images...)
+		}
+	}
+
+	return nil
+}
+
+// readHerb fetches the herb identified by the :id URL parameter. If it can't, it sends
+// the appropriate error response and returns false.
+func (app *application) readHerb(w http.ResponseWriter, r *http.Request) (*data.Herb, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	herb, err := app.models.Herbs.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return herb, true
+}
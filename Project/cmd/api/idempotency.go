@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"gourmetspices.yerassyl.net/internal/data"
+)
+
+// idempotencyKeyRX matches the Idempotency-Key header values we accept.
+var idempotencyKeyRX = regexp.MustCompile(`^[\x21-\x7E]{1,255}$`)
+
+// idempotentHeaders are the response headers stored along with the status and body of
+// an idempotent request, and sent again on replays.
+var idempotentHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// idempotencyReplayable reports whether a response with the given status is stored
+// for retries to replay. Server errors, conflicts, failed preconditions and rate
+// limiting depend on the state of the server at the time rather than on the request,
+// so a retry of those gets handled again.
+func idempotencyReplayable(status int) bool {
+	switch status {
+	case http.StatusConflict, http.StatusPreconditionFailed, http.StatusTooManyRequests:
+		return false
+	default:
+		return status < 500
+	}
+}
+
+// idempotencyOwner returns who an Idempotency-Key sent with the request belongs to:
+// the signed in user, or for anonymous requests the client's IP address.
+func (app *application) idempotencyOwner(r *http.Request) string {
+	user := app.contextGetUser(r)
+	if !user.IsAnonymous() {
+		return fmt.Sprintf("user:%d", user.ID)
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	return "ip:" + ip
+}
+
+// idempotencyRecorder passes a response through to the client while keeping a copy of
+// it to store.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.header = rec.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// idempotent makes POST requests which carry an Idempotency-Key header safe to retry.
+// The first request with a key is handled as usual and its response stored, and later
+// requests by the same client with the same key get that response back, with an
+// Idempotent-Replayed header, until the key expires. A key can't be reused for a
+// different request, and a retry which arrives while the first request is still being
+// handled is turned away. Responses which idempotencyReplayable rules out aren't
+// stored, so those requests can be retried for real. Requests for tokens are left
+// alone, since their responses carry credentials which mustn't be stored.
+func (app *application) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+
+		if r.Method != http.MethodPost || key == "" || strings.HasPrefix(r.URL.Path, "/v1/tokens/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !idempotencyKeyRX.MatchString(key) {
+			app.badRequestResponse(w, r, errors.New("Idempotency-Key header must be 1 to 255 printable ASCII characters"))
+			return
+		}
+
+		// The body has to be read up front to tell whether a retry is the same request.
+		maxBytes := app.config.idempotency.maxBodyBytes
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+
+			switch {
+			case errors.As(err, &maxBytesError):
+				app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytes))
+			default:
+				app.badRequestResponse(w, r, err)
+			}
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.RequestURI())
+		hash.Write(body)
+
+		owner := app.idempotencyOwner(r)
+
+		response, err := app.models.IdempotencyKeys.Begin(owner, key, hash.Sum(nil), app.config.idempotency.ttl)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrIdempotencyKeyMismatch):
+				app.idempotencyKeyMismatchResponse(w, r)
+			case errors.Is(err, data.ErrIdempotencyKeyInProgress):
+				app.idempotencyKeyInProgressResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if response != nil {
+			for name, value := range response.Header {
+				w.Header().Set(name, value)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+
+			w.WriteHeader(response.StatusCode)
+			w.Write(response.Body)
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+
+		// Give the key up if the handler panics or its response isn't to be replayed.
+		completed := false
+		defer func() {
+			if !completed {
+				err := app.models.IdempotencyKeys.Release(owner, key)
+				if err != nil {
+					app.logError(r, err)
+				}
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+			rec.header = rec.Header().Clone()
+		}
+
+		if !idempotencyReplayable(rec.status) {
+			return
+		}
+
+		completed = true
+
+		response = &data.IdempotentResponse{
+			StatusCode: rec.status,
+			Header:     make(map[string]string),
+			Body:       rec.body.Bytes(),
+		}
+
+		for _, name := range idempotentHeaders {
+			if value := rec.header.Get(name); value != "" {
+				response.Header[name] = value
+			}
+		}
+
+		err = app.models.IdempotencyKeys.Complete(owner, key, response)
+		if err != nil {
+			app.logError(r, err)
+		}
+	})
+}
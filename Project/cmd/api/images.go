@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	"gourmetspices.yerassyl.net/internal/data"
+	"gourmetspices.yerassyl.net/internal/storage"
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+// imageExtensions maps the accepted image content types to the file extension they are
+// stored with, which is also what the media handler serves the content type from.
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// uploadHerbImageHandler stores an image sent as the "image" field of a multipart form
+// and adds it after the herb's existing images. The thumbnail is generated in the
+// background, so it may be missing from the response.
+func (app *application) uploadHerbImageHandler(w http.ResponseWriter, r *http.Request) {
+	herb, ok := app.readHerb(w, r)
+	if !ok {
+		return
+	}
+
+	file, err := app.readUpload(w, r, "image")
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	image := &data.HerbImage{
+		HerbID:      herb.ID,
+		ContentType: http.DetectContentType(file),
+		SizeBytes:   int64(len(file)),
+	}
+
+	v := validator.New()
+
+	if data.ValidateHerbImage(v, image); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	image.ImageKey, err = newImageKey(herb.ID, imageExtensions[image.ContentType])
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.storage.Put(image.ImageKey, bytes.NewReader(file))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.HerbImages.Insert(image)
+	if err != nil {
+		app.deleteStoredFiles(image.ImageKey)
+
+		switch {
+		case errors.Is(err, data.ErrUnknownHerb):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.background(func() {
+		app.generateThumbnail(image.ID, image.ImageKey, file)
+	})
+
+	app.setImageURLs(image)
+
+	headers := make(http.Header)
+	headers.Set("Location", image.URL)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"image": image}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteHerbImageHandler(w http.ResponseWriter, r *http.Request) {
+	herbID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	id, err := app.readInt64Param(r, "image_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	image, err := app.models.HerbImages.Delete(herbID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.deleteStoredFiles(image.ImageKey, image.ThumbnailKey)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "image successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showMediaHandler serves a file from storage. Keys are random and never reused, so
+// clients may cache the files for as long as they like.
+func (app *application) showMediaHandler(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(httprouter.ParamsFromContext(r.Context()).ByName("filepath"), "/")
+
+	file, err := app.storage.Open(key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrInvalidKey):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	_, err = io.Copy(w, file)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// The readUpload() helper reads the file sent in the named field of a multipart/form-data
+// request body. readJSON() caps bodies at 1MB, which is too small for images, so uploads
+// are limited by the -upload-max-bytes setting instead.
+func (app *application) readUpload(w http.ResponseWriter, r *http.Request, field string) ([]byte, error) {
+	maxBytes := app.config.storage.uploadMaxBytes
+
+	// Leave some room for the part headers and boundaries on top of the file itself.
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+64*1024)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, errors.New("body must be multipart/form-data")
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			switch {
+			case errors.Is(err, io.EOF):
+				return nil, fmt.Errorf("body must contain a file in the %q field", field)
+			case errors.As(err, &maxBytesError):
+				return nil, fmt.Errorf("file must not be larger than %d bytes", maxBytes)
+			default:
+				return nil, errors.New("body contains a badly-formed multipart form")
+			}
+		}
+
+		if part.FormName() != field {
+			continue
+		}
+
+		file, err := io.ReadAll(io.LimitReader(part, maxBytes+1))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				return nil, fmt.Errorf("file must not be larger than %d bytes", maxBytes)
+			}
+			return nil, errors.New("body contains a badly-formed multipart form")
+		}
+
+		if int64(len(file)) > maxBytes {
+			return nil, fmt.Errorf("file must not be larger than %d bytes", maxBytes)
+		}
+
+		return file, nil
+	}
+}
+
+// setImageURLs fills in the URLs of the given images from their storage keys.
+func (app *application) setImageURLs(images ...*data.HerbImage) {
+	for _, image := range images {
+		image.URL = app.storage.URL(image.ImageKey)
+		if image.ThumbnailKey != "" {
+			image.ThumbnailURL = app.storage.URL(image.ThumbnailKey)
+		}
+	}
+}
+
+// deleteStoredFiles removes the files with the given keys from storage in the
+// background, logging any failures. Empty keys are skipped.
+func (app *application) deleteStoredFiles(keys ...string) {
+	app.background(func() {
+		for _, key := range keys {
+			if key == "" {
+				continue
+			}
+
+			err := app.storage.Delete(key)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{
+					"key": key,
+				})
+			}
+		}
+	})
+}
+
+// newImageKey returns a new random storage key for an image of the herb.
+func newImageKey(herbID int64, ext string) (string, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("herbs/%d/%s%s", herbID, hex.EncodeToString(b), ext), nil
+}
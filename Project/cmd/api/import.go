@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gourmetspices.yerassyl.net/internal/data"
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+// maxImportRows caps the number of herbs a single import may add.
+const maxImportRows = 10_000
+
+// herbImportColumns are the CSV columns an import may have. Prices use the same
+// "12.50 USD" format as the JSON representation, and culinary uses are separated by
+// semicolons.
+var herbImportColumns = []string{"name", "description", "price", "culinary_uses", "stock_unit"}
+
+// importRowError holds the validation errors for one row of an import. Row is the line
+// of the file the row starts on.
+type importRowError struct {
+	Row    int               `json:"row"`
+	Errors map[string]string `json:"errors"`
+}
+
+type importReport struct {
+	DryRun   bool             `json:"dry_run"`
+	Rows     int              `json:"rows"`
+	Inserted int              `json:"inserted"`
+	HerbIDs  []int64          `json:"herb_ids,omitempty"`
+	Errors   []importRowError `json:"errors"`
+}
+
+// importHerbsHandler adds every herb in a CSV or NDJSON request body. The format is
+// taken from the "format" query string parameter or, failing that, the Content-Type.
+// Nothing is inserted unless every row is valid, and with dry_run=true nothing is
+// inserted at all; either way the response lists the errors for each invalid row.
+func (app *application) importHerbsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	format := app.readString(qs, "format", importFormat(r.Header.Get("Content-Type")))
+	dryRun := app.readString(qs, "dry_run", "false")
+
+	v.Check(validator.In(format, "csv", "ndjson"), "format", "must be one of csv, ndjson")
+	v.Check(validator.In(dryRun, "true", "false"), "dry_run", "must be true or false")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.storage.uploadMaxBytes)
+
+	herbs, report, err := readHerbImport(r.Body, format)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			err = fmt.Errorf("body must not be larger than %d bytes", app.config.storage.uploadMaxBytes)
+		}
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	report.DryRun = dryRun == "true"
+
+	if len(report.Errors) > 0 && !report.DryRun {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, report)
+		return
+	}
+
+	status := http.StatusOK
+
+	if !report.DryRun {
+		err = app.models.Herbs.InsertMany(herbs, app.actor(r))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		report.Inserted = len(herbs)
+		for _, herb := range herbs {
+			report.HerbIDs = append(report.HerbIDs, herb.ID)
+		}
+
+		status = http.StatusCreated
+	}
+
+	err = app.writeJSON(w, status, envelope{"import": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// importCommand implements "api import", which imports a CSV or NDJSON file from the
+// command line in the same way as POST /v1/herbs/import, and prints the report.
+func importCommand(args []string) error {
+	var cfg config
+
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: api import [flags] <file>")
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("GOURMETSPICES_DB_DSN"), "PostgreSQL DSN")
+	format := fs.String("format", "", "File format (csv|ndjson), by default taken from the file extension")
+	dryRun := fs.Bool("dry-run", false, "Validate the file without importing anything")
+
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("exactly one file must be given")
+	}
+
+	if cfg.db.dsn == "" {
+		return errors.New("a PostgreSQL DSN must be given with -db-dsn or GOURMETSPICES_DB_DSN")
+	}
+
+	name := fs.Arg(0)
+
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+	}
+	if !validator.In(*format, "csv", "ndjson") {
+		return fmt.Errorf("unknown format %q, must be one of csv, ndjson", *format)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The database is needed even for a dry run, since prices can only be validated
+	// once the currencies we accept have been loaded from it.
+	cfg.db.maxOpenConns = 1
+	cfg.db.maxIdleConns = 1
+	cfg.db.maxIdleTime = "15m"
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	models := data.NewModels(db)
+
+	err = models.Currencies.Load()
+	if err != nil {
+		return err
+	}
+
+	herbs, report, err := readHerbImport(f, *format)
+	if err != nil {
+		return err
+	}
+
+	report.DryRun = *dryRun
+
+	if len(report.Errors) == 0 && !report.DryRun {
+		err = models.Herbs.InsertMany(herbs, data.Actor{})
+		if err != nil {
+			return err
+		}
+
+		report.Inserted = len(herbs)
+		for _, herb := range herbs {
+			report.HerbIDs = append(report.HerbIDs, herb.ID)
+		}
+	}
+
+	js, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(js))
+
+	if len(report.Errors) > 0 {
+		return fmt.Errorf("%d of %d rows are invalid", len(report.Errors), report.Rows)
+	}
+
+	return nil
+}
+
+// importFormat returns the import format for a Content-Type header, or the empty
+// string if it isn't one we know.
+func importFormat(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch mediaType {
+	case "text/csv":
+		return "csv"
+	case "application/x-ndjson", "application/ndjson":
+		return "ndjson"
+	default:
+		return ""
+	}
+}
+
+// readHerbImport reads and validates the herbs in an import file. Rows which fail
+// validation are reported rather than returned as an error; an error means that the
+// file as a whole couldn't be read.
+func readHerbImport(r io.Reader, format string) ([]*data.Herb, importReport, error) {
+	report := importReport{Errors: []importRowError{}}
+	herbs := []*data.Herb{}
+
+	add := func(row int, herb *data.Herb, v *validator.Validator) error {
+		report.Rows++
+		if report.Rows > maxImportRows {
+			return fmt.Errorf("file must not contain more than %d rows", maxImportRows)
+		}
+
+		if herb != nil {
+			if herb.StockUnit == "" {
+				herb.StockUnit = "grams"
+			}
+			data.ValidateHerb(v, herb)
+		}
+
+		if !v.Valid() {
+			report.Errors = append(report.Errors, importRowError{Row: row, Errors: v.Errors})
+			return nil
+		}
+
+		herbs = append(herbs, herb)
+		return nil
+	}
+
+	var err error
+
+	switch format {
+	case "csv":
+		err = readHerbCSV(r, add)
+	case "ndjson":
+		err = readHerbNDJSON(r, add)
+	default:
+		err = fmt.Errorf("unknown import format %q", format)
+	}
+	if err != nil {
+		return nil, importReport{}, err
+	}
+
+	if report.Rows == 0 {
+		return nil, importReport{}, errors.New("file must contain at least 1 row")
+	}
+
+	return herbs, report, nil
+}
+
+// readHerbCSV reads herbs from a CSV file with a header row naming the columns, and
+// calls add for each of them. The herb is nil if the row couldn't be read at all.
+func readHerbCSV(r io.Reader, add func(int, *data.Herb, *validator.Validator) error) error {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("file must not be empty")
+		}
+		return csvError(err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		if !validator.In(name, herbImportColumns...) {
+			return fmt.Errorf("file contains unknown column %q", name)
+		}
+		if _, exists := columns[name]; exists {
+			return fmt.Errorf("file contains column %q more than once", name)
+		}
+
+		columns[name] = i
+	}
+
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		v := validator.New()
+
+		if err != nil {
+			var parseError *csv.ParseError
+			if !errors.As(err, &parseError) || !errors.Is(err, csv.ErrFieldCount) {
+				return csvError(err)
+			}
+
+			v.AddError("row", fmt.Sprintf("must have %d fields", len(header)))
+			err = add(parseError.StartLine, nil, v)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		row, _ := cr.FieldPos(0)
+
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		herb := &data.Herb{
+			Name:         field("name"),
+			Description:  field("description"),
+			CulinaryUses: []string{},
+			StockUnit:    field("stock_unit"),
+		}
+
+		if price := field("price"); price != "" {
+			herb.Price, err = data.ParsePrice(price)
+			if err != nil {
+				v.AddError("price", `must be a price such as "12.50 USD"`)
+			}
+		}
+
+		for _, use := range strings.Split(field("culinary_uses"), ";") {
+			if use = strings.TrimSpace(use); use != "" {
+				herb.CulinaryUses = append(herb.CulinaryUses, use)
+			}
+		}
+
+		err = add(row, herb, v)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readHerbNDJSON reads herbs from a file with one JSON object per line, in the same
+// shape as the body of POST /v1/herbs, and calls add for each of them. Blank lines are
+// skipped. The herb is nil if the line couldn't be decoded.
+func readHerbNDJSON(r io.Reader, add func(int, *data.Herb, *validator.Validator) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1_048_576)
+
+	row := 0
+
+	for scanner.Scan() {
+		row++
+
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var input struct {
+			Name         string     `json:"name"`
+			Description  string     `json:"description"`
+			Price        data.Price `json:"price"`
+			CulinaryUses []string   `json:"culinary_uses"`
+			StockUnit    string     `json:"stock_unit"`
+		}
+
+		v := validator.New()
+
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+
+		err := dec.Decode(&input)
+		if err == nil && dec.More() {
+			err = errors.New("must only contain a single JSON value")
+		}
+		if err != nil {
+			key, message := ndjsonError(err)
+			v.AddError(key, message)
+
+			err = add(row, nil, v)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		herb := &data.Herb{
+			Name:         input.Name,
+			Description:  input.Description,
+			Price:        input.Price,
+			CulinaryUses: input.CulinaryUses,
+			StockUnit:    input.StockUnit,
+		}
+
+		err = add(row, herb, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := scanner.Err()
+	if errors.Is(err, bufio.ErrTooLong) {
+		return fmt.Errorf("file contains a line longer than 1048576 bytes (after line %d)", row)
+	}
+	return err
+}
+
+// csvError describes an error which stopped a CSV file from being read.
+func csvError(err error) error {
+	var parseError *csv.ParseError
+	if errors.As(err, &parseError) {
+		return fmt.Errorf("file contains badly-formed CSV (at line %d)", parseError.Line)
+	}
+	return err
+}
+
+// ndjsonError returns the key and message to report for a line which couldn't be
+// decoded.
+func ndjsonError(err error) (string, string) {
+	var syntaxError *json.SyntaxError
+	var unmarshalTypeError *json.UnmarshalTypeError
+
+	switch {
+	case errors.Is(err, data.ErrInvalidPriceFormat), errors.Is(err, data.ErrUnsupportedCurrency):
+		return "price", `must be a price such as "12.50 USD"`
+	case errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field != "":
+		return unmarshalTypeError.Field, "has the wrong JSON type"
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return "row", "contains unknown key " + strings.TrimPrefix(err.Error(), "json: unknown field ")
+	case errors.As(err, &syntaxError), errors.Is(err, io.ErrUnexpectedEOF):
+		return "row", "must be a valid JSON object"
+	default:
+		return "row", err.Error()
+	}
+}
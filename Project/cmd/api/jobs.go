@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// startJobs launches the periodic background jobs. Like the rate limiter's cleanup, they
+// run for the life of the process.
+func (app *application) startJobs() {
+	app.every(app.config.jobs.priceSchedulerInterval, app.applyScheduledPrices)
+	app.every(app.config.jobs.herbPurgeInterval, app.purgeDeletedHerbs)
+	app.every(app.config.jobs.idempotencyCleanupInterval, app.deleteExpiredIdempotencyKeys)
+}
+
+// every runs fn in a background goroutine once per interval, logging any errors it
+// returns. A zero interval disables the job.
+func (app *application) every(interval time.Duration, fn func() error) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			func() {
+				defer func() {
+					if err := recover(); err != nil {
+						app.logger.PrintError(fmt.Errorf("%s", err), nil)
+					}
+				}()
+
+				err := fn()
+				if err != nil {
+					app.logger.PrintError(err, nil)
+				}
+			}()
+		}
+	}()
+}
+
+func (app *application) applyScheduledPrices() error {
+	applied, err := app.models.ScheduledPrices.ApplyDue()
+	if err != nil {
+		return err
+	}
+
+	if applied > 0 {
+		app.logger.PrintInfo("applied scheduled prices", map[string]string{
+			"count": fmt.Sprint(applied),
+		})
+	}
+
+	return nil
+}
+
+// purgeDeletedHerbs permanently deletes the herbs which have been in the trash for
+// longer than the retention period, and removes their images from storage.
+func (app *application) purgeDeletedHerbs() error {
+	purged, keys, err := app.models.Herbs.Purge(time.Now().Add(-app.config.jobs.herbRetention))
+	if err != nil {
+		return err
+	}
+
+	app.deleteStoredFiles(keys...)
+
+	if purged > 0 {
+		app.logger.PrintInfo("purged deleted herbs", map[string]string{
+			"count": fmt.Sprint(purged),
+		})
+	}
+
+	return nil
+}
+
+func (app *application) deleteExpiredIdempotencyKeys() error {
+	deleted, err := app.models.IdempotencyKeys.DeleteExpired()
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		app.logger.PrintInfo("deleted expired idempotency keys", map[string]string{
+			"count": fmt.Sprint(deleted),
+		})
+	}
+
+	return nil
+}
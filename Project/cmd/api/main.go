@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"flag"
+	"fmt"
 	"os"
 	"strings"
 	"sync"
@@ -12,6 +13,7 @@ import (
 	"gourmetspices.yerassyl.net/internal/data"
 	"gourmetspices.yerassyl.net/internal/jsonlog"
 	"gourmetspices.yerassyl.net/internal/mailer"
+	"gourmetspices.yerassyl.net/internal/storage"
 
 	_ "github.com/lib/pq"
 )
@@ -32,6 +34,12 @@ type config struct {
 		burst   int
 		enabled bool
 	}
+	activation struct {
+		resendInterval time.Duration
+	}
+	passwordReset struct {
+		interval time.Duration
+	}
 	smtp struct {
 		host     string
 		port     int
@@ -42,17 +50,44 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	jobs struct {
+		priceSchedulerInterval     time.Duration
+		herbPurgeInterval          time.Duration
+		herbRetention              time.Duration
+		idempotencyCleanupInterval time.Duration
+	}
+	idempotency struct {
+		ttl          time.Duration
+		maxBodyBytes int64
+	}
+	storage struct {
+		dir            string
+		uploadMaxBytes int64
+	}
 }
 
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config  config
+	logger  *jsonlog.Logger
+	models  data.Models
+	mailer  mailer.Mailer
+	storage storage.Storage
+	wg      sync.WaitGroup
+
+	activationThrottle    *emailThrottle
+	passwordResetThrottle *emailThrottle
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		err := importCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var cfg config
 
 	flag.IntVar(&cfg.port, "port", 4000, "API server port")
@@ -68,6 +103,9 @@ func main() {
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
 
+	flag.DurationVar(&cfg.activation.resendInterval, "activation-resend-interval", 5*time.Minute, "Minimum time between activation emails resent to the same address")
+	flag.DurationVar(&cfg.passwordReset.interval, "password-reset-interval", 5*time.Minute, "Minimum time between password reset emails sent to the same address")
+
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
 	flag.StringVar(&cfg.smtp.username, "smtp-username", "ed45646c3f8e5b", "SMTP username")
@@ -79,6 +117,17 @@ func main() {
 		return nil
 	})
 
+	flag.DurationVar(&cfg.jobs.priceSchedulerInterval, "price-scheduler-interval", time.Minute, "How often scheduled herb prices are applied (0 to disable)")
+	flag.DurationVar(&cfg.jobs.herbPurgeInterval, "herb-purge-interval", time.Hour, "How often deleted herbs past their retention period are purged (0 to disable)")
+	flag.DurationVar(&cfg.jobs.herbRetention, "herb-retention", 30*24*time.Hour, "How long deleted herbs are kept in the trash before being purged")
+	flag.DurationVar(&cfg.jobs.idempotencyCleanupInterval, "idempotency-cleanup-interval", time.Hour, "How often expired idempotency keys are deleted (0 to disable)")
+
+	flag.DurationVar(&cfg.idempotency.ttl, "idempotency-ttl", 24*time.Hour, "How long responses to requests with an Idempotency-Key are kept for replay")
+	flag.Int64Var(&cfg.idempotency.maxBodyBytes, "idempotency-max-body-bytes", 6_291_456, "Maximum size in bytes of a request body sent with an Idempotency-Key")
+
+	flag.StringVar(&cfg.storage.dir, "storage-dir", "./uploads", "Directory uploaded files are stored in")
+	flag.Int64Var(&cfg.storage.uploadMaxBytes, "upload-max-bytes", 5_242_880, "Maximum size of an uploaded file in bytes")
+
 	flag.Parse()
 
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
@@ -91,11 +140,26 @@ func main() {
 
 	logger.PrintInfo("database connection pool established", nil)
 
+	store, err := storage.NewLocal(cfg.storage.dir, "/v1/media")
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:  cfg,
+		logger:  logger,
+		models:  data.NewModels(db),
+		mailer:  mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		storage: store,
+
+		activationThrottle:    newEmailThrottle(cfg.activation.resendInterval),
+		passwordResetThrottle: newEmailThrottle(cfg.passwordReset.interval),
+	}
+
+	// Prices can only be parsed once we know which currencies we accept.
+	err = app.models.Currencies.Load()
+	if err != nil {
+		logger.PrintFatal(err, nil)
 	}
 
 	err = app.serve()
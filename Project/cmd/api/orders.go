@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gourmetspices.yerassyl.net/internal/data"
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+// createOrderHandler checks out the authenticated user's cart, redeeming the coupon code
+// if one is given. If any price has changed since it was added, the cart is refreshed
+// to the current prices and the client gets a 409 so that the user can review them
+// before trying again.
+func (app *application) createOrderHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Currency   string `json:"currency"`
+		CouponCode string `json:"coupon_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Currency == "" {
+		input.Currency = data.DefaultCurrency
+	}
+
+	v := validator.New()
+
+	v.Check(data.SupportedCurrency(input.Currency), "currency", "must be a supported currency")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	order, err := app.models.Orders.Place(user.ID, input.Currency, strings.ToUpper(input.CouponCode), app.actor(r))
+	if err != nil {
+		switch {
+		case couponErrorMessage(err) != "":
+			v.AddError("coupon_code", couponErrorMessage(err))
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEmptyCart):
+			v.AddError("cart", "must contain at least 1 item")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrInsufficientStock):
+			v.AddError("cart", "contains more of a herb than is in stock")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrExchangeRateNotFound):
+			v.AddError("currency", "has no exchange rate for every price in the cart")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrPriceChanged):
+			err = app.models.Carts.RefreshPrices(user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			app.priceChangedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/orders/%d", order.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"order": order}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showOrderHandler(w http.ResponseWriter, r *http.Request) {
+	order, ok := app.readOrder(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"order": order}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateOrderStatusHandler moves an order through pending -> paid -> shipped, or
+// cancels it. Users may cancel their own pending orders; anything else needs the
+// orders:write permission.
+func (app *application) updateOrderStatusHandler(w http.ResponseWriter, r *http.Request) {
+	order, ok := app.readOrder(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Status  string `json:"status"`
+		Version *int32 `json:"version"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Version != nil {
+		order.Version = *input.Version
+	}
+
+	v := validator.New()
+
+	v.Check(validator.In(input.Status, data.OrderStatuses...), "status", "must be one of pending, paid, shipped, cancelled")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	ownCancellation := order.UserID == user.ID && order.Status == data.OrderPending && input.Status == data.OrderCancelled
+	if !ownCancellation {
+		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !permissions.Include("orders:write") {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	err = app.models.Orders.Transition(order, input.Status, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidStatusTransition):
+			v.AddError("status", "is not a valid next status for this order")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"order": order}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listOrdersHandler returns the authenticated user's orders. Users with the orders:read
+// permission see everybody's orders, and can narrow them down with the user parameter.
+func (app *application) listOrdersHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		UserID int64
+		Status string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.UserID = int64(app.readInt(qs, "user", 0, v))
+	input.Status = app.readString(qs, "status", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+
+	v.Check(input.UserID >= 0, "user", "must be a valid user id")
+	if input.Status != "" {
+		v.Check(validator.In(input.Status, data.OrderStatuses...), "status", "must be one of pending, paid, shipped, cancelled")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !permissions.Include("orders:read") {
+		input.UserID = user.ID
+	}
+
+	orders, metadata, err := app.models.Orders.GetAll(input.UserID, input.Status, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"orders": orders, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readOrder fetches the order identified by the :id URL parameter. Orders belonging to
+// other users are reported as not found unless the user has the orders:read
+// permission. If it can't, it sends the appropriate error response and returns false.
+func (app *application) readOrder(w http.ResponseWriter, r *http.Request) (*data.Order, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	order, err := app.models.Orders.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	user := app.contextGetUser(r)
+
+	if order.UserID != user.ID {
+		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return nil, false
+		}
+
+		if !permissions.Include("orders:read") {
+			app.notFoundResponse(w, r)
+			return nil, false
+		}
+	}
+
+	return order, true
+}
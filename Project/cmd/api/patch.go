@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+
+	"gourmetspices.yerassyl.net/internal/data"
+	"gourmetspices.yerassyl.net/internal/jsonpatch"
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+// The media types of the patch formats PATCH /v1/herbs/:id accepts besides plain JSON.
+const (
+	mergePatchMediaType = "application/merge-patch+json"
+	jsonPatchMediaType  = "application/json-patch+json"
+)
+
+// patchMediaType returns the media type of the request body if it is one of the patch
+// formats, and "" otherwise.
+func patchMediaType(r *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+
+	switch mediaType {
+	case mergePatchMediaType, jsonPatchMediaType:
+		return mediaType
+	default:
+		return ""
+	}
+}
+
+// herbDocument is the document that patches to a herb are applied to. The ID and
+// Version are there for JSON Patch test operations and can't be changed.
+type herbDocument struct {
+	ID           int64      `json:"id"`
+	Version      int32      `json:"version"`
+	Name         string     `json:"name"`
+	Description  string     `json:"description"`
+	Price        data.Price `json:"price"`
+	CulinaryUses []string   `json:"culinary_uses"`
+	StockUnit    string     `json:"stock_unit"`
+}
+
+// patchHerb applies the Merge Patch or JSON Patch in the request body to herb. If the
+// patch can't be applied it sends the error response and returns false: 400 for
+// malformed patches, 409 when a test operation fails, and 422 for paths which don't
+// exist and for results which aren't a valid herb document.
+func (app *application) patchHerb(w http.ResponseWriter, r *http.Request, herb *data.Herb, mediaType string) bool {
+	var patch json.RawMessage
+
+	err := app.readJSON(w, r, &patch)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return false
+	}
+
+	doc := herbDocument{
+		ID:           herb.ID,
+		Version:      herb.Version,
+		Name:         herb.Name,
+		Description:  herb.Description,
+		Price:        herb.Price,
+		CulinaryUses: herb.CulinaryUses,
+		StockUnit:    herb.StockUnit,
+	}
+	if doc.CulinaryUses == nil {
+		doc.CulinaryUses = []string{}
+	}
+
+	js, err := json.Marshal(doc)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if mediaType == mergePatchMediaType {
+		js, err = jsonpatch.MergePatch(js, patch)
+	} else {
+		js, err = jsonpatch.Apply(js, patch)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, jsonpatch.ErrTestFailed):
+			app.patchTestFailedResponse(w, r, err)
+		case errors.Is(err, jsonpatch.ErrPathNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"patch": err.Error()})
+		case errors.Is(err, jsonpatch.ErrInvalidPatch):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+
+	var patched herbDocument
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&patched)
+	if err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+
+		v := validator.New()
+
+		switch {
+		case errors.Is(err, data.ErrInvalidPriceFormat), errors.Is(err, data.ErrUnsupportedCurrency):
+			v.AddError("price", `must be a price such as "12.50 USD"`)
+		case errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field != "":
+			v.AddError(unmarshalTypeError.Field, "has the wrong JSON type")
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			v.AddError("patch", "adds unknown key "+strings.TrimPrefix(err.Error(), "json: unknown field "))
+		default:
+			v.AddError("patch", "must leave the herb a JSON object")
+		}
+
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	v := validator.New()
+
+	v.Check(patched.ID == herb.ID, "id", "cannot be changed")
+	v.Check(patched.Version == herb.Version, "version", "cannot be changed")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	herb.Name = patched.Name
+	herb.Description = patched.Description
+	herb.Price = patched.Price
+	herb.CulinaryUses = patched.CulinaryUses
+	herb.StockUnit = patched.StockUnit
+
+	return true
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gourmetspices.yerassyl.net/internal/data"
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+func (app *application) listPriceHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	herb, ok := app.readHerb(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-changed_at")
+	input.Filters.SortSafelist = []string{"id", "changed_at", "-id", "-changed_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	changes, metadata, err := app.models.PriceHistory.GetAllForHerb(herb.ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"price_history": changes, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createScheduledPriceHandler(w http.ResponseWriter, r *http.Request) {
+	herb, ok := app.readHerb(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Price       data.Price `json:"price"`
+		EffectiveAt time.Time  `json:"effective_at"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	sp := &data.ScheduledPrice{
+		HerbID:      herb.ID,
+		Price:       input.Price,
+		EffectiveAt: input.EffectiveAt,
+		CreatedBy:   app.contextGetUser(r).ID,
+	}
+
+	v := validator.New()
+
+	if data.ValidateScheduledPrice(v, sp); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.ScheduledPrices.Insert(sp)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownHerb):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/herbs/%d/scheduled-prices", herb.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"scheduled_price": sp}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listScheduledPricesHandler returns the herb's pending scheduled prices, or all of them
+// including those already applied when ?all=true.
+func (app *application) listScheduledPricesHandler(w http.ResponseWriter, r *http.Request) {
+	herb, ok := app.readHerb(w, r)
+	if !ok {
+		return
+	}
+
+	all := app.readString(r.URL.Query(), "all", "false")
+
+	v := validator.New()
+
+	v.Check(validator.In(all, "true", "false"), "all", "must be true or false")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	prices, err := app.models.ScheduledPrices.GetAllForHerb(herb.ID, all == "true")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"scheduled_prices": prices}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteScheduledPriceHandler(w http.ResponseWriter, r *http.Request) {
+	herbID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	id, err := app.readInt64Param(r, "scheduled_price_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.ScheduledPrices.Delete(herbID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "scheduled price successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
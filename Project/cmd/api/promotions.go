@@ -0,0 +1,371 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gourmetspices.yerassyl.net/internal/data"
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+func (app *application) createPromotionHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Code           string      `json:"code"`
+		Name           string      `json:"name"`
+		Kind           string      `json:"kind"`
+		PercentOff     int32       `json:"percent_off"`
+		AmountOff      *data.Price `json:"amount_off"`
+		MinOrderValue  *data.Price `json:"min_order_value"`
+		StartsAt       *time.Time  `json:"starts_at"`
+		ExpiresAt      *time.Time  `json:"expires_at"`
+		HerbIDs        []int64     `json:"herb_ids"`
+		CategoryIDs    []int64     `json:"category_ids"`
+		CulinaryUses   []string    `json:"culinary_uses"`
+		MaxUses        int32       `json:"max_uses"`
+		MaxUsesPerUser int32       `json:"max_uses_per_user"`
+		Active         *bool       `json:"active"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	promotion := &data.Promotion{
+		Code:           strings.ToUpper(input.Code),
+		Name:           input.Name,
+		Kind:           input.Kind,
+		PercentOff:     input.PercentOff,
+		AmountOff:      input.AmountOff,
+		MinOrderValue:  input.MinOrderValue,
+		StartsAt:       time.Now().Truncate(time.Second),
+		ExpiresAt:      input.ExpiresAt,
+		HerbIDs:        input.HerbIDs,
+		CategoryIDs:    input.CategoryIDs,
+		CulinaryUses:   input.CulinaryUses,
+		MaxUses:        input.MaxUses,
+		MaxUsesPerUser: input.MaxUsesPerUser,
+		Active:         true,
+	}
+
+	if input.StartsAt != nil {
+		promotion.StartsAt = *input.StartsAt
+	}
+	if input.Active != nil {
+		promotion.Active = *input.Active
+	}
+	if promotion.HerbIDs == nil {
+		promotion.HerbIDs = []int64{}
+	}
+	if promotion.CategoryIDs == nil {
+		promotion.CategoryIDs = []int64{}
+	}
+	if promotion.CulinaryUses == nil {
+		promotion.CulinaryUses = []string{}
+	}
+
+	v := validator.New()
+
+	if data.ValidatePromotion(v, promotion); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Promotions.Insert(promotion)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateCouponCode):
+			v.AddError("code", "a promotion with this code already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/promotions/%d", promotion.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"promotion": promotion}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showPromotionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	promotion, err := app.models.Promotions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"promotion": promotion}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updatePromotionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	promotion, err := app.models.Promotions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Code           *string     `json:"code"`
+		Name           *string     `json:"name"`
+		Kind           *string     `json:"kind"`
+		PercentOff     *int32      `json:"percent_off"`
+		AmountOff      *data.Price `json:"amount_off"`
+		MinOrderValue  *data.Price `json:"min_order_value"`
+		StartsAt       *time.Time  `json:"starts_at"`
+		ExpiresAt      *time.Time  `json:"expires_at"`
+		HerbIDs        []int64     `json:"herb_ids"`
+		CategoryIDs    []int64     `json:"category_ids"`
+		CulinaryUses   []string    `json:"culinary_uses"`
+		MaxUses        *int32      `json:"max_uses"`
+		MaxUsesPerUser *int32      `json:"max_uses_per_user"`
+		Active         *bool       `json:"active"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Code != nil {
+		promotion.Code = strings.ToUpper(*input.Code)
+	}
+	if input.Name != nil {
+		promotion.Name = *input.Name
+	}
+	// Switching kind drops the other kind's discount, so that clients only have to send
+	// the new one.
+	if input.Kind != nil && *input.Kind != promotion.Kind {
+		promotion.Kind = *input.Kind
+		promotion.PercentOff = 0
+		promotion.AmountOff = nil
+	}
+	if input.PercentOff != nil {
+		promotion.PercentOff = *input.PercentOff
+	}
+	if input.AmountOff != nil {
+		promotion.AmountOff = input.AmountOff
+	}
+	if input.MinOrderValue != nil {
+		promotion.MinOrderValue = input.MinOrderValue
+	}
+	if input.StartsAt != nil {
+		promotion.StartsAt = *input.StartsAt
+	}
+	if input.ExpiresAt != nil {
+		promotion.ExpiresAt = input.ExpiresAt
+	}
+	if input.HerbIDs != nil {
+		promotion.HerbIDs = input.HerbIDs
+	}
+	if input.CategoryIDs != nil {
+		promotion.CategoryIDs = input.CategoryIDs
+	}
+	if input.CulinaryUses != nil {
+		promotion.CulinaryUses = input.CulinaryUses
+	}
+	if input.MaxUses != nil {
+		promotion.MaxUses = *input.MaxUses
+	}
+	if input.MaxUsesPerUser != nil {
+		promotion.MaxUsesPerUser = *input.MaxUsesPerUser
+	}
+	if input.Active != nil {
+		promotion.Active = *input.Active
+	}
+
+	v := validator.New()
+
+	if data.ValidatePromotion(v, promotion); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Promotions.Update(promotion)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateCouponCode):
+			v.AddError("code", "a promotion with this code already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"promotion": promotion}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deletePromotionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Promotions.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "promotion successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listPromotionsHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Kind string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Kind = app.readString(qs, "kind", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafelist = []string{"id", "name", "starts_at", "-id", "-name", "-starts_at"}
+
+	if input.Kind != "" {
+		v.Check(validator.In(input.Kind, "coupon", "sale"), "kind", "must be one of coupon, sale")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	promotions, metadata, err := app.models.Promotions.GetAll(input.Kind, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"promotions": promotions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// validateCouponHandler reports what a coupon code would take off the authenticated
+// user's cart, without redeeming it.
+func (app *application) validateCouponHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Code     string `json:"code"`
+		Currency string `json:"currency"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	input.Code = strings.ToUpper(input.Code)
+	if input.Currency == "" {
+		input.Currency = data.DefaultCurrency
+	}
+
+	v := validator.New()
+
+	v.Check(input.Code != "", "code", "must be provided")
+	v.Check(data.SupportedCurrency(input.Currency), "currency", "must be a supported currency")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	quote, err := app.models.Promotions.Quote(app.contextGetUser(r).ID, input.Code, input.Currency)
+	if err != nil {
+		switch {
+		case couponErrorMessage(err) != "":
+			v.AddError("code", couponErrorMessage(err))
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEmptyCart):
+			v.AddError("cart", "must contain at least 1 item")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrExchangeRateNotFound):
+			v.AddError("currency", "has no exchange rate for every price in the cart")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"coupon": quote}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// couponErrorMessage returns the validation message for an error returned when a coupon
+// can't be used, or the empty string if err isn't one of those.
+func couponErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, data.ErrInvalidCoupon):
+		return "is not a valid coupon code"
+	case errors.Is(err, data.ErrCouponExpired):
+		return "has expired"
+	case errors.Is(err, data.ErrCouponLimitReached):
+		return "has reached its usage limit"
+	case errors.Is(err, data.ErrCouponMinOrderValue):
+		return "requires a higher order value"
+	case errors.Is(err, data.ErrCouponNotApplicable):
+		return "does not apply to any item in the cart"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"gourmetspices.yerassyl.net/internal/data"
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+// purchaseOrderLineInput is the client-supplied part of a purchase order line.
+type purchaseOrderLineInput struct {
+	HerbID   int64      `json:"herb_id"`
+	Quantity int64      `json:"quantity"`
+	UnitCost data.Price `json:"unit_cost"`
+}
+
+func newPurchaseOrderLines(input []purchaseOrderLineInput) []*data.PurchaseOrderLine {
+	if input == nil {
+		return nil
+	}
+
+	lines := make([]*data.PurchaseOrderLine, len(input))
+	for i, line := range input {
+		lines[i] = &data.PurchaseOrderLine{
+			HerbID:   line.HerbID,
+			Quantity: line.Quantity,
+			UnitCost: line.UnitCost,
+		}
+	}
+	return lines
+}
+
+func (app *application) createPurchaseOrderHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		SupplierID int64                    `json:"supplier_id"`
+		Lines      []purchaseOrderLineInput `json:"lines"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	po := &data.PurchaseOrder{
+		SupplierID: input.SupplierID,
+		Lines:      newPurchaseOrderLines(input.Lines),
+	}
+
+	v := validator.New()
+
+	if data.ValidatePurchaseOrder(v, po); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.PurchaseOrders.Insert(po)
+	if err != nil {
+		app.purchaseOrderErrorResponse(w, r, v, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/purchase-orders/%d", po.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"purchase_order": po}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showPurchaseOrderHandler(w http.ResponseWriter, r *http.Request) {
+	po, ok := app.readPurchaseOrder(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"purchase_order": po}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updatePurchaseOrderHandler(w http.ResponseWriter, r *http.Request) {
+	po, ok := app.readPurchaseOrder(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+
+	if po.Status != data.PurchaseOrderDraft {
+		v.AddError("status", "only draft purchase orders can be edited")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var input struct {
+		SupplierID *int64                   `json:"supplier_id"`
+		Lines      []purchaseOrderLineInput `json:"lines"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.SupplierID != nil {
+		po.SupplierID = *input.SupplierID
+	}
+	if input.Lines != nil {
+		po.Lines = newPurchaseOrderLines(input.Lines)
+	}
+
+	if data.ValidatePurchaseOrder(v, po); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.PurchaseOrders.Update(po)
+	if err != nil {
+		app.purchaseOrderErrorResponse(w, r, v, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"purchase_order": po}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updatePurchaseOrderStatusHandler moves a purchase order through draft -> sent ->
+// received. Receiving an order books its lines into stock.
+func (app *application) updatePurchaseOrderStatusHandler(w http.ResponseWriter, r *http.Request) {
+	po, ok := app.readPurchaseOrder(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Status  string `json:"status"`
+		Version *int32 `json:"version"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Version != nil {
+		po.Version = *input.Version
+	}
+
+	v := validator.New()
+
+	v.Check(validator.In(input.Status, data.PurchaseOrderStatuses...), "status", "must be one of draft, sent, received")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.PurchaseOrders.Transition(po, input.Status, app.actor(r))
+	if err != nil {
+		app.purchaseOrderErrorResponse(w, r, v, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"purchase_order": po}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deletePurchaseOrderHandler(w http.ResponseWriter, r *http.Request) {
+	po, ok := app.readPurchaseOrder(w, r)
+	if !ok {
+		return
+	}
+
+	if po.Status != data.PurchaseOrderDraft {
+		v := validator.New()
+		v.AddError("status", "only draft purchase orders can be deleted")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.PurchaseOrders.Delete(po.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "purchase order successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listPurchaseOrdersHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		SupplierID int64
+		Status     string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.SupplierID = int64(app.readInt(qs, "supplier", 0, v))
+	input.Status = app.readString(qs, "status", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+
+	v.Check(input.SupplierID >= 0, "supplier", "must be a valid supplier id")
+	if input.Status != "" {
+		v.Check(validator.In(input.Status, data.PurchaseOrderStatuses...), "status", "must be one of draft, sent, received")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	orders, metadata, err := app.models.PurchaseOrders.GetAll(input.SupplierID, input.Status, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"purchase_orders": orders, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readPurchaseOrder fetches the purchase order identified by the :id URL parameter. If
+// it can't, it sends the appropriate error response and returns false.
+func (app *application) readPurchaseOrder(w http.ResponseWriter, r *http.Request) (*data.PurchaseOrder, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	po, err := app.models.PurchaseOrders.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return po, true
+}
+
+// purchaseOrderErrorResponse sends the response for an error returned when writing a
+// purchase order.
+func (app *application) purchaseOrderErrorResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator, err error) {
+	switch {
+	case errors.Is(err, data.ErrUnknownSupplier):
+		v.AddError("supplier_id", "refers to a supplier that does not exist")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrUnknownHerb):
+		v.AddError("lines", "refers to a herb that does not exist")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrInvalidStatusTransition):
+		v.AddError("status", "is not a valid next status for this purchase order")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
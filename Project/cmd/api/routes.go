@@ -17,13 +17,116 @@ func (app *application) routes() http.Handler {
 
 	router.HandlerFunc(http.MethodGet, "/v1/herbs", app.requirePermission("herbs:read", app.listHerbsHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/herbs", app.requirePermission("herbs:write", app.createHerbHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/herbs/:id", app.requirePermission("herbs:read", app.showHerbHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/herbs/:id", app.herbActions(map[string]http.HandlerFunc{
+		"export": app.requirePermission("herbs:read", app.exportHerbsHandler),
+		"trash":  app.requirePermission("herbs:write", app.listDeletedHerbsHandler),
+	}, app.requirePermission("herbs:read", app.showHerbHandler)))
 	router.HandlerFunc(http.MethodPatch, "/v1/herbs/:id", app.requirePermission("herbs:write", app.updateHerbHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/herbs/:id", app.requirePermission("herbs:write", app.deleteHerbHandler))
 
+	router.HandlerFunc(http.MethodPost, "/v1/herbs/:id", app.herbActions(map[string]http.HandlerFunc{
+		"import": app.requirePermission("herbs:write", app.importHerbsHandler),
+		"batch":  app.requirePermission("herbs:write", app.batchHerbsHandler),
+	}, nil))
+	router.HandlerFunc(http.MethodPost, "/v1/herbs/:id/restore", app.requirePermission("herbs:write", app.restoreHerbHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/herbs/:id/variants", app.requirePermission("herbs:read", app.listHerbVariantsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/herbs/:id/variants", app.requirePermission("herbs:write", app.createHerbVariantHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/herbs/:id/variants/:variant_id", app.requirePermission("herbs:read", app.showHerbVariantHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/herbs/:id/variants/:variant_id", app.requirePermission("herbs:write", app.updateHerbVariantHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/herbs/:id/variants/:variant_id", app.requirePermission("herbs:write", app.deleteHerbVariantHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/herbs/:id/categories", app.requirePermission("herbs:read", app.listHerbCategoriesHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/herbs/:id/categories", app.requirePermission("herbs:write", app.setHerbCategoriesHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/herbs/:id/images", app.requirePermission("herbs:write", app.uploadHerbImageHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/herbs/:id/images/:image_id", app.requirePermission("herbs:write", app.deleteHerbImageHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/herbs/:id/stock", app.requirePermission("herbs:read", app.showHerbStockHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/herbs/:id/stock-movements", app.requirePermission("herbs:read", app.listStockMovementsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/herbs/:id/stock-movements", app.requirePermission("herbs:write", app.createStockMovementHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/herbs/:id/price-history", app.requirePermission("herbs:read", app.listPriceHistoryHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/herbs/:id/scheduled-prices", app.requirePermission("herbs:read", app.listScheduledPricesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/herbs/:id/scheduled-prices", app.requirePermission("herbs:write", app.createScheduledPriceHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/herbs/:id/scheduled-prices/:scheduled_price_id", app.requirePermission("herbs:write", app.deleteScheduledPriceHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/herbs/:id/reviews", app.requirePermission("herbs:read", app.listReviewsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/herbs/:id/reviews", app.requireActivatedUser(app.createReviewHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/reviews/:id", app.requireActivatedUser(app.updateReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/reviews/:id", app.requireActivatedUser(app.deleteReviewHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/reviews/:id/visibility", app.requirePermission("reviews:moderate", app.updateReviewVisibilityHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/categories", app.requirePermission("herbs:read", app.listCategoriesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/categories", app.requirePermission("categories:write", app.createCategoryHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/categories/:id", app.requirePermission("herbs:read", app.showCategoryHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/categories/:id", app.requirePermission("categories:write", app.updateCategoryHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/categories/:id", app.requirePermission("categories:write", app.deleteCategoryHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/suppliers", app.requirePermission("suppliers:read", app.listSuppliersHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/suppliers", app.requirePermission("suppliers:write", app.createSupplierHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/suppliers/:id", app.requirePermission("suppliers:read", app.showSupplierHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/suppliers/:id", app.requirePermission("suppliers:write", app.updateSupplierHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/suppliers/:id", app.requirePermission("suppliers:write", app.deleteSupplierHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/purchase-orders", app.requirePermission("purchasing:read", app.listPurchaseOrdersHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/purchase-orders", app.requirePermission("purchasing:write", app.createPurchaseOrderHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/purchase-orders/:id", app.requirePermission("purchasing:read", app.showPurchaseOrderHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/purchase-orders/:id", app.requirePermission("purchasing:write", app.updatePurchaseOrderHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/purchase-orders/:id", app.requirePermission("purchasing:write", app.deletePurchaseOrderHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/purchase-orders/:id/status", app.requirePermission("purchasing:write", app.updatePurchaseOrderStatusHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/cart", app.requireActivatedUser(app.showCartHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/cart/items/:id", app.requireActivatedUser(app.setCartItemHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/cart/items/:id", app.requireActivatedUser(app.deleteCartItemHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/orders", app.requireActivatedUser(app.listOrdersHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/orders", app.requireActivatedUser(app.createOrderHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/orders/:id", app.requireActivatedUser(app.showOrderHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/orders/:id/status", app.requireActivatedUser(app.updateOrderStatusHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/promotions", app.requirePermission("promotions:read", app.listPromotionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/promotions", app.requirePermission("promotions:write", app.createPromotionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/promotions/:id", app.requirePermission("promotions:read", app.showPromotionHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/promotions/:id", app.requirePermission("promotions:write", app.updatePromotionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/promotions/:id", app.requirePermission("promotions:write", app.deletePromotionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/coupons/validate", app.requireActivatedUser(app.validateCouponHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/media/*filepath", app.showMediaHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/exchange-rates", app.requirePermission("herbs:read", app.listExchangeRatesHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/exchange-rates", app.requirePermission("rates:write", app.updateExchangeRatesHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/audit-events", app.requirePermission("audit:read", app.listAuditEventsHandler))
+
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+
+	return app.recoverPanic(app.requestID(app.enableCORS(app.rateLimit(app.authenticate(app.idempotent(router))))))
+}
+
+// herbActions dispatches requests for fixed paths such as /v1/herbs/import to the
+// matching handler in actions. httprouter doesn't allow those to be registered next to
+// /v1/herbs/:id, so they are routed on the value of the :id parameter instead. Any
+// other value goes to next, or gets a 404 if next is nil.
+func (app *application) herbActions(actions map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		action := httprouter.ParamsFromContext(r.Context()).ByName("id")
+
+		if handler, ok := actions[action]; ok {
+			handler(w, r)
+			return
+		}
+
+		if next == nil {
+			app.notFoundResponse(w, r)
+			return
+		}
 
-	return app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router))))
+		next(w, r)
+	}
 }
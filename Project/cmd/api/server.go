@@ -47,6 +47,8 @@ func (app *application) serve() error {
 		shutdownError <- nil
 	}()
 
+	app.startJobs()
+
 	app.logger.PrintInfo("starting server", map[string]string{
 		"addr": srv.Addr,
 		"env":  app.config.env,
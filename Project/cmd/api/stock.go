@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"gourmetspices.yerassyl.net/internal/data"
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+func (app *application) showHerbStockHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	variantID := app.readInt(r.URL.Query(), "variant_id", 0, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	stock, err := app.models.StockMovements.GetStock(id, int64(variantID))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"stock": stock}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createStockMovementHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		VariantID int64  `json:"variant_id"`
+		Kind      string `json:"kind"`
+		Quantity  int64  `json:"quantity"`
+		Note      string `json:"note"`
+		Version   *int32 `json:"version"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	stock, err := app.models.StockMovements.GetStock(id, input.VariantID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movement := &data.StockMovement{
+		HerbID:    stock.HerbID,
+		VariantID: stock.VariantID,
+		Kind:      input.Kind,
+		Quantity:  input.Quantity,
+		Note:      input.Note,
+		UserID:    app.contextGetUser(r).ID,
+	}
+
+	if movement.Kind == data.StockMovementSell {
+		movement.Quantity = -movement.Quantity
+	}
+
+	// If the client tells us which version of the stock it based the movement on, use
+	// that. Otherwise apply the movement on top of the version we just read.
+	version := stock.Version
+	if input.Version != nil {
+		version = *input.Version
+	}
+
+	v := validator.New()
+
+	v.Check(version > 0, "version", "must be greater than zero")
+
+	if data.ValidateStockMovement(v, movement); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.StockMovements.Insert(movement, version, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInsufficientStock):
+			v.AddError("quantity", "must not take the stock on hand below zero")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	stock.Quantity = movement.Balance
+	stock.Version = movement.StockVersion
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"stock_movement": movement, "stock": stock}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listStockMovementsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Herbs.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		VariantID int
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.VariantID = app.readInt(qs, "variant_id", 0, v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafelist = []string{"id", "-id"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movements, metadata, err := app.models.StockMovements.GetAllForHerb(id, int64(input.VariantID), input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"stock_movements": movements, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
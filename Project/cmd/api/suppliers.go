@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"gourmetspices.yerassyl.net/internal/data"
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+func (app *application) createSupplierHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Name         string  `json:"name"`
+		ContactName  string  `json:"contact_name"`
+		Email        string  `json:"email"`
+		Phone        string  `json:"phone"`
+		Country      string  `json:"country"`
+		LeadTimeDays int32   `json:"lead_time_days"`
+		HerbIDs      []int64 `json:"herb_ids"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	supplier := &data.Supplier{
+		Name:         input.Name,
+		ContactName:  input.ContactName,
+		Email:        input.Email,
+		Phone:        input.Phone,
+		Country:      input.Country,
+		LeadTimeDays: input.LeadTimeDays,
+		HerbIDs:      input.HerbIDs,
+	}
+
+	if supplier.HerbIDs == nil {
+		supplier.HerbIDs = []int64{}
+	}
+
+	v := validator.New()
+
+	if data.ValidateSupplier(v, supplier); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Suppliers.Insert(supplier)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownHerb):
+			v.AddError("herb_ids", "refers to a herb that does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/suppliers/%d", supplier.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"supplier": supplier}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showSupplierHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	supplier, err := app.models.Suppliers.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"supplier": supplier}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateSupplierHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	supplier, err := app.models.Suppliers.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name         *string `json:"name"`
+		ContactName  *string `json:"contact_name"`
+		Email        *string `json:"email"`
+		Phone        *string `json:"phone"`
+		Country      *string `json:"country"`
+		LeadTimeDays *int32  `json:"lead_time_days"`
+		HerbIDs      []int64 `json:"herb_ids"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		supplier.Name = *input.Name
+	}
+	if input.ContactName != nil {
+		supplier.ContactName = *input.ContactName
+	}
+	if input.Email != nil {
+		supplier.Email = *input.Email
+	}
+	if input.Phone != nil {
+		supplier.Phone = *input.Phone
+	}
+	if input.Country != nil {
+		supplier.Country = *input.Country
+	}
+	if input.LeadTimeDays != nil {
+		supplier.LeadTimeDays = *input.LeadTimeDays
+	}
+	if input.HerbIDs != nil {
+		supplier.HerbIDs = input.HerbIDs
+	}
+
+	v := validator.New()
+	if data.ValidateSupplier(v, supplier); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Suppliers.Update(supplier)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownHerb):
+			v.AddError("herb_ids", "refers to a herb that does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"supplier": supplier}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSupplierHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Suppliers.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrSupplierInUse):
+			v := validator.New()
+			v.AddError("supplier", "has purchase orders and cannot be deleted")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "supplier successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listSuppliersHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Name    string
+		Country string
+		HerbID  int64
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+	input.Country = app.readString(qs, "country", "")
+	input.HerbID = int64(app.readInt(qs, "herb", 0, v))
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "country", "lead_time_days", "-id", "-name", "-country", "-lead_time_days"}
+
+	v.Check(input.HerbID >= 0, "herb", "must be a valid herb id")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suppliers, metadata, err := app.models.Suppliers.GetAll(input.Name, input.Country, input.HerbID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"suppliers": suppliers, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
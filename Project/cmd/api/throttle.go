@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// emailThrottle limits how often we send email to the same address, so that endpoints
+// which send email on request can't be used to flood someone's inbox. Like the rate
+// limiter it keeps its state in memory.
+type emailThrottle struct {
+	interval time.Duration
+	mu       sync.Mutex
+	lastSent map[string]time.Time
+}
+
+// newEmailThrottle returns a throttle which allows one email per address per interval.
+func newEmailThrottle(interval time.Duration) *emailThrottle {
+	t := &emailThrottle{
+		interval: interval,
+		lastSent: make(map[string]time.Time),
+	}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			t.mu.Lock()
+
+			for email, sent := range t.lastSent {
+				if time.Since(sent) > t.interval {
+					delete(t.lastSent, email)
+				}
+			}
+
+			t.mu.Unlock()
+		}
+	}()
+
+	return t
+}
+
+// Allow reports whether an email may be sent to the address now, and if so counts it as
+// sent. Addresses are compared case-insensitively.
+func (t *emailThrottle) Allow(email string) bool {
+	email = strings.ToLower(email)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if sent, ok := t.lastSent[email]; ok && time.Since(sent) < t.interval {
+		return false
+	}
+
+	t.lastSent[email] = time.Now()
+	return true
+}
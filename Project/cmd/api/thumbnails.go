@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"path"
+	"strings"
+
+	"gourmetspices.yerassyl.net/internal/data"
+)
+
+const (
+	// thumbnailSize is the length of the longer side of a thumbnail, in pixels.
+	thumbnailSize = 320
+	// maxImagePixels guards against small files which decode to enormous images.
+	maxImagePixels = 50_000_000
+)
+
+// generateThumbnail scales the uploaded image down to a thumbnail, stores it next to
+// the image and records its key. It is run with app.background(), so failures are
+// logged rather than returned.
+func (app *application) generateThumbnail(imageID int64, imageKey string, file []byte) {
+	thumbnail, ext, err := makeThumbnail(file)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{
+			"image_key": imageKey,
+		})
+		return
+	}
+
+	key := strings.TrimSuffix(imageKey, path.Ext(imageKey)) + "_thumb" + ext
+
+	err = app.storage.Put(key, bytes.NewReader(thumbnail))
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{
+			"image_key": imageKey,
+		})
+		return
+	}
+
+	err = app.models.HerbImages.SetThumbnail(imageID, key)
+	if err != nil {
+		// The image was deleted while we were working on it, so the thumbnail isn't
+		// needed any more.
+		app.deleteStoredFiles(key)
+
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			app.logger.PrintError(err, map[string]string{
+				"image_key": imageKey,
+			})
+		}
+	}
+}
+
+// makeThumbnail decodes the image in file and returns it scaled to fit within
+// thumbnailSize, together with the extension of the format it was encoded in. JPEGs
+// stay JPEGs; anything else becomes a PNG so that transparency is kept.
+func makeThumbnail(file []byte) ([]byte, string, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(file))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return nil, "", fmt.Errorf("image of %dx%d pixels is too large for a thumbnail", config.Width, config.Height)
+	}
+
+	src, format, err := image.Decode(bytes.NewReader(file))
+	if err != nil {
+		return nil, "", err
+	}
+
+	dst := scaleDown(src, thumbnailSize)
+
+	var buf bytes.Buffer
+
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+		return buf.Bytes(), ".jpg", err
+	}
+
+	err = png.Encode(&buf, dst)
+	return buf.Bytes(), ".png", err
+}
+
+// scaleDown shrinks src so that neither side is longer than size, keeping its aspect
+// ratio. Each destination pixel is the average of the source pixels it covers. Images
+// which already fit are returned unchanged.
+func scaleDown(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if width <= size && height <= size {
+		return src
+	}
+
+	dstWidth, dstHeight := size, height*size/width
+	if height > width {
+		dstWidth, dstHeight = width*size/height, size
+	}
+	if dstWidth < 1 {
+		dstWidth = 1
+	}
+	if dstHeight < 1 {
+		dstHeight = 1
+	}
+
+	dst := image.NewRGBA64(image.Rect(0, 0, dstWidth, dstHeight))
+
+	for y := 0; y < dstHeight; y++ {
+		y0 := bounds.Min.Y + y*height/dstHeight
+		y1 := bounds.Min.Y + (y+1)*height/dstHeight
+
+		for x := 0; x < dstWidth; x++ {
+			x0 := bounds.Min.X + x*width/dstWidth
+			x1 := bounds.Min.X + (x+1)*width/dstWidth
+
+			var r, g, b, a, n uint64
+
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r += uint64(pr)
+					g += uint64(pg)
+					b += uint64(pb)
+					a += uint64(pa)
+					n++
+				}
+			}
+
+			dst.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+
+	return dst
+}
@@ -62,3 +62,150 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// createPasswordResetTokenHandler emails a password reset token to the user with the
+// given email address. The response is the same whether or not there is such a user,
+// so that it can't be used to find out which email addresses are registered.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := envelope{"message": "if an account with that email address exists, an email will be sent to it with password reset instructions"}
+
+	// Everything which depends on whether the address is registered happens in the
+	// background, so that the response takes the same time either way. Each address
+	// gets at most one email per -password-reset-interval.
+	if app.passwordResetThrottle.Allow(input.Email) {
+		app.background(func() {
+			err := app.sendPasswordResetToken(input.Email)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// sendPasswordResetToken emails a new password reset token to the user with the given
+// email address, if there is one. Only the newest token works, so an email which
+// arrives late can't be used after a later one.
+func (app *application) sendPasswordResetToken(email string) error {
+	user, err := app.models.Users.GetByEmail(email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil
+		default:
+			return err
+		}
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	if err != nil {
+		return err
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{
+		"passwordResetToken": token.Plaintext,
+	}
+
+	return app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+}
+
+// createActivationTokenHandler sends a new activation token to a user who hasn't
+// activated their account yet, replacing any they were sent before. Each address gets
+// at most one email per -activation-resend-interval. As with password resets the
+// response is always the same, so that it can't be used to find out which email
+// addresses are registered.
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := envelope{"message": "if an account with that email address is waiting to be activated, an email will be sent to it with activation instructions"}
+
+	// As with password resets the lookup and token work happen in the background, so
+	// that the response takes the same time whether or not the address is registered.
+	if app.activationThrottle.Allow(input.Email) {
+		app.background(func() {
+			err := app.sendActivationToken(input.Email)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// sendActivationToken emails a new activation token to the user with the given email
+// address, if there is one and it hasn't been activated yet, replacing any tokens they
+// were sent before.
+func (app *application) sendActivationToken(email string) error {
+	user, err := app.models.Users.GetByEmail(email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil
+		default:
+			return err
+		}
+	}
+
+	if user.Activated {
+		return nil
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	if err != nil {
+		return err
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{
+		"activationToken": token.Plaintext,
+	}
+
+	return app.mailer.Send(user.Email, "token_activation.tmpl", data)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"gourmetspices.yerassyl.net/internal/data"
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+func (app *application) createHerbVariantHandler(w http.ResponseWriter, r *http.Request) {
+	herbID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Herbs.Get(herbID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		SKU           string     `json:"sku"`
+		PackSizeGrams int32      `json:"pack_size_grams"`
+		Packaging     string     `json:"packaging"`
+		WeightGrams   int32      `json:"weight_grams"`
+		Price         data.Price `json:"price"`
+		Barcode       string     `json:"barcode"`
+		StockQuantity int64      `json:"stock_quantity"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	variant := &data.HerbVariant{
+		HerbID:        herbID,
+		SKU:           input.SKU,
+		PackSizeGrams: input.PackSizeGrams,
+		Packaging:     input.Packaging,
+		WeightGrams:   input.WeightGrams,
+		Price:         input.Price,
+		Barcode:       input.Barcode,
+		StockQuantity: input.StockQuantity,
+	}
+
+	v := validator.New()
+
+	if data.ValidateHerbVariant(v, variant); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.HerbVariants.Insert(variant, app.actor(r))
+	if err != nil {
+		app.variantErrorResponse(w, r, v, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/herbs/%d/variants/%d", herbID, variant.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"variant": variant}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listHerbVariantsHandler(w http.ResponseWriter, r *http.Request) {
+	herbID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Herbs.Get(herbID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	variants, err := app.models.HerbVariants.GetAllForHerbs([]int64{herbID})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	list := variants[herbID]
+	if list == nil {
+		list = []*data.HerbVariant{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"variants": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showHerbVariantHandler(w http.ResponseWriter, r *http.Request) {
+	variant, ok := app.readHerbVariant(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"variant": variant}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateHerbVariantHandler(w http.ResponseWriter, r *http.Request) {
+	variant, ok := app.readHerbVariant(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		SKU           *string     `json:"sku"`
+		PackSizeGrams *int32      `json:"pack_size_grams"`
+		Packaging     *string     `json:"packaging"`
+		WeightGrams   *int32      `json:"weight_grams"`
+		Price         *data.Price `json:"price"`
+		Barcode       *string     `json:"barcode"`
+		StockQuantity *int64      `json:"stock_quantity"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.SKU != nil {
+		variant.SKU = *input.SKU
+	}
+	if input.PackSizeGrams != nil {
+		variant.PackSizeGrams = *input.PackSizeGrams
+	}
+	if input.Packaging != nil {
+		variant.Packaging = *input.Packaging
+	}
+	if input.WeightGrams != nil {
+		variant.WeightGrams = *input.WeightGrams
+	}
+	if input.Price != nil {
+		variant.Price = *input.Price
+	}
+	if input.Barcode != nil {
+		variant.Barcode = *input.Barcode
+	}
+
+	v := validator.New()
+
+	// Stock is only changed through stock movements, so that it all goes through the ledger.
+	v.Check(input.StockQuantity == nil, "stock_quantity", "must be changed through stock movements")
+
+	if data.ValidateHerbVariant(v, variant); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.HerbVariants.Update(variant)
+	if err != nil {
+		app.variantErrorResponse(w, r, v, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"variant": variant}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteHerbVariantHandler(w http.ResponseWriter, r *http.Request) {
+	herbID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	id, err := app.readInt64Param(r, "variant_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.HerbVariants.Delete(herbID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "variant successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readHerbVariant fetches the variant identified by the :id and :variant_id URL
+// parameters. If it can't, it sends the appropriate error response and returns false.
+func (app *application) readHerbVariant(w http.ResponseWriter, r *http.Request) (*data.HerbVariant, bool) {
+	herbID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	id, err := app.readInt64Param(r, "variant_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	variant, err := app.models.HerbVariants.Get(herbID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return variant, true
+}
+
+// variantErrorResponse sends the response for an error returned when writing a variant.
+func (app *application) variantErrorResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator, err error) {
+	switch {
+	case errors.Is(err, data.ErrDuplicateSKU):
+		v.AddError("sku", "a variant with this sku already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrDuplicateBarcode):
+		v.AddError("barcode", "a variant with this barcode already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
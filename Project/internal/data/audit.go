@@ -0,0 +1,208 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// AuditActions are the kinds of change recorded in the audit trail.
+var AuditActions = []string{"create", "update", "delete", "restore", "purge"}
+
+// AuditEntityTypes are the kinds of record whose changes are audited.
+var AuditEntityTypes = []string{"herb", "user"}
+
+// Actor identifies who made a change and the request it was made in, for the audit
+// trail. UserID is 0 when nobody was signed in, and the whole Actor is empty for
+// changes made by background jobs and the command line.
+type Actor struct {
+	UserID    int64
+	RequestID string
+	IP        string
+}
+
+// AuditChange is the value of one field before and after a change. Before is null for
+// records being created, and After for records being deleted.
+type AuditChange struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+type AuditEvent struct {
+	ID         int64                  `json:"id"`
+	CreatedAt  time.Time              `json:"created_at"`
+	ActorID    int64                  `json:"actor_id,omitempty"`
+	Action     string                 `json:"action"`
+	EntityType string                 `json:"entity_type"`
+	EntityID   int64                  `json:"entity_id"`
+	Changes    map[string]AuditChange `json:"changes"`
+	RequestID  string                 `json:"request_id,omitempty"`
+	IP         string                 `json:"ip,omitempty"`
+}
+
+// AuditEventFilter narrows down the events returned by GetAll. Zero fields match every
+// event.
+type AuditEventFilter struct {
+	ActorID    int64
+	Action     string
+	EntityType string
+	EntityID   int64
+	Since      time.Time // Events at or after this time
+	Until      time.Time // Events before this time
+}
+
+// auditDiff returns the fields whose JSON representation differs between before and
+// after. Either may be nil, for records being created or deleted.
+func auditDiff(before, after interface{}) (map[string]AuditChange, error) {
+	fields := func(v interface{}) (map[string]json.RawMessage, error) {
+		m := make(map[string]json.RawMessage)
+		if v == nil {
+			return m, nil
+		}
+
+		js, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(js, &m)
+		return m, err
+	}
+
+	beforeFields, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+
+	afterFields, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]AuditChange)
+
+	for key, value := range beforeFields {
+		if !bytes.Equal(value, afterFields[key]) {
+			changes[key] = AuditChange{Before: value, After: afterFields[key]}
+		}
+	}
+
+	for key, value := range afterFields {
+		if _, ok := beforeFields[key]; !ok {
+			changes[key] = AuditChange{After: value}
+		}
+	}
+
+	return changes, nil
+}
+
+// insertAuditEvent records a change made by actor, using q, which should be the
+// transaction the change was made in so that the two are committed together. The
+// changes are the difference between before and after.
+func insertAuditEvent(ctx context.Context, q dbtx, actor Actor, action, entityType string, entityID int64, before, after interface{}) error {
+	changes, err := auditDiff(before, after)
+	if err != nil {
+		return err
+	}
+
+	return insertAuditChanges(ctx, q, actor, action, entityType, entityID, changes)
+}
+
+// insertAuditChanges is insertAuditEvent for callers which have worked out the changes
+// themselves.
+func insertAuditChanges(ctx context.Context, q dbtx, actor Actor, action, entityType string, entityID int64, changes map[string]AuditChange) error {
+	js, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO audit_events (actor_id, action, entity_type, entity_id, changes, request_id, ip)
+		VALUES (NULLIF($1, 0), $2, $3, $4, $5, $6, $7)`
+
+	args := []interface{}{actor.UserID, action, entityType, entityID, js, actor.RequestID, actor.IP}
+
+	_, err = q.ExecContext(ctx, query, args...)
+	return err
+}
+
+type AuditEventModel struct {
+	DB *sql.DB
+}
+
+// GetAll returns a page of the audit events matching filter.
+func (m AuditEventModel) GetAll(filter AuditEventFilter, filters Filters) ([]*AuditEvent, Metadata, error) {
+	query := fmt.Sprintf(`SELECT count(*) OVER(), id, created_at, coalesce(actor_id, 0), action, entity_type, entity_id, changes, request_id, ip
+		FROM audit_events
+		WHERE (actor_id = $1 OR $1 = 0)
+		AND (action = $2 OR $2 = '')
+		AND (entity_type = $3 OR $3 = '')
+		AND (entity_id = $4 OR $4 = 0)
+		AND (created_at >= $5 OR $5::timestamptz IS NULL)
+		AND (created_at < $6 OR $6::timestamptz IS NULL)
+		ORDER BY %s %s, id ASC
+		LIMIT $7 OFFSET $8`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{
+		filter.ActorID,
+		filter.Action,
+		filter.EntityType,
+		filter.EntityID,
+		sql.NullTime{Time: filter.Since, Valid: !filter.Since.IsZero()},
+		sql.NullTime{Time: filter.Until, Valid: !filter.Until.IsZero()},
+		filters.limit(),
+		filters.offset(),
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		var (
+			event   AuditEvent
+			changes []byte
+		)
+
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.CreatedAt,
+			&event.ActorID,
+			&event.Action,
+			&event.EntityType,
+			&event.EntityID,
+			&changes,
+			&event.RequestID,
+			&event.IP,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		err = json.Unmarshal(changes, &event.Changes)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return events, metadata, nil
+}
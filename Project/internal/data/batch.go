@@ -0,0 +1,94 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// HerbOperations are the kinds of operation a herb batch can contain.
+var HerbOperations = []string{"create", "update", "delete"}
+
+// HerbOperation is one step of a herb batch. Creates and updates carry the Herb to
+// save, which for updates must have the ID and the Version the client expects it to be
+// at. Deletes carry just the ID and expected Version.
+type HerbOperation struct {
+	Op      string
+	Herb    *Herb
+	ID      int64
+	Version int32
+}
+
+// Batch runs the operations in order, recording each in the audit trail against actor,
+// and returns the error for each operation that failed (nil for those that didn't).
+// In atomic mode they all run in one transaction, and the first failure rolls back
+// every operation and stops the batch; otherwise each runs in its own transaction and
+// failures of any kind don't affect the others, so that there is an error or nil for
+// every operation whichever of them committed. The returned error is for problems with
+// the batch as a whole.
+func (h HerbModel) Batch(ops []*HerbOperation, atomic bool, actor Actor) ([]error, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	errs := make([]error, len(ops))
+
+	if atomic {
+		tx, err := h.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+
+		for i, op := range ops {
+			errs[i] = runHerbOperation(ctx, tx, op, actor)
+			if errs[i] != nil {
+				if isOperationError(errs[i]) {
+					return errs, nil
+				}
+				return nil, errs[i]
+			}
+		}
+
+		return errs, tx.Commit()
+	}
+
+	for i, op := range ops {
+		errs[i] = func() error {
+			tx, err := h.DB.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			err = runHerbOperation(ctx, tx, op, actor)
+			if err != nil {
+				return err
+			}
+
+			return tx.Commit()
+		}()
+	}
+
+	return errs, nil
+}
+
+// isOperationError reports whether err is the fault of a single operation, rather than
+// something which stops the whole batch.
+func isOperationError(err error) bool {
+	return errors.Is(err, ErrRecordNotFound) || errors.Is(err, ErrEditConflict)
+}
+
+func runHerbOperation(ctx context.Context, tx *sql.Tx, op *HerbOperation, actor Actor) error {
+	switch op.Op {
+	case "create":
+		return insertHerb(ctx, tx, op.Herb, actor)
+	case "update":
+		return updateHerb(ctx, tx, op.Herb, actor)
+	case "delete":
+		return deleteHerb(ctx, tx, op.ID, op.Version, actor)
+	default:
+		return fmt.Errorf("unknown herb operation %q", op.Op)
+	}
+}
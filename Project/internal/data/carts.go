@@ -0,0 +1,216 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+// CartItem is a herb in a user's cart. UnitPrice is the herb's price at the time it
+// was added, which is checked against the current price again at checkout.
+type CartItem struct {
+	HerbID       int64  `json:"herb_id"`
+	HerbName     string `json:"herb_name"`
+	Quantity     int64  `json:"quantity"`
+	UnitPrice    Price  `json:"unit_price"`
+	SalePrice    *Price `json:"sale_price,omitempty"` // The herb's current sale price, which checkout charges instead
+	LineTotal    Price  `json:"line_total"`
+	PriceChanged bool   `json:"price_changed,omitempty"` // The herb's price has changed since it was added
+	herb         Herb   // The parts of the herb that sales are matched against
+}
+
+type Cart struct {
+	Items    []*CartItem `json:"items"`
+	Subtotal Price       `json:"subtotal"`
+}
+
+func ValidateCartQuantity(v *validator.Validator, quantity int64) {
+	v.Check(quantity > 0, "quantity", "must be greater than zero")
+	v.Check(quantity <= 100_000, "quantity", "must not be more than 100000")
+}
+
+// Total works out the line totals and subtotal of the cart in the converter's currency,
+// using sale prices where the items have them.
+func (c *Cart) Total(converter *Converter) error {
+	c.Subtotal = Price{Currency: converter.Currency}
+
+	for _, item := range c.Items {
+		price := item.UnitPrice
+		if item.SalePrice != nil {
+			price = *item.SalePrice
+		}
+
+		unitPrice, err := converter.Convert(price)
+		if err != nil {
+			return err
+		}
+
+		item.LineTotal, err = unitPrice.Mul(item.Quantity)
+		if err != nil {
+			return err
+		}
+
+		c.Subtotal, err = c.Subtotal.Add(item.LineTotal)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type CartModel struct {
+	DB *sql.DB
+}
+
+func (m CartModel) Get(userID int64) (*Cart, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	items, err := getCartItems(ctx, m.DB, userID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	herbs := make([]*Herb, len(items))
+	for i, item := range items {
+		herbs[i] = &item.herb
+	}
+
+	err = applySales(ctx, m.DB, herbs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range items {
+		item.SalePrice = item.herb.SalePrice
+	}
+
+	return &Cart{Items: items}, nil
+}
+
+// SetItem puts a herb in the user's cart, or changes its quantity if it is already
+// there. Either way the item's price is refreshed to the herb's current price.
+func (m CartModel) SetItem(userID, herbID, quantity int64) error {
+	query := `INSERT INTO cart_items (user_id, herb_id, quantity, unit_price_amount, unit_price_currency)
+		SELECT $1, herbs.id, $3, herbs.price_amount, herbs.price_currency FROM herbs WHERE herbs.id = $2 AND herbs.deleted_at IS NULL
+		ON CONFLICT (user_id, herb_id) DO UPDATE
+		SET quantity = EXCLUDED.quantity,
+			unit_price_amount = EXCLUDED.unit_price_amount,
+			unit_price_currency = EXCLUDED.unit_price_currency`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, herbID, quantity)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrUnknownHerb
+	}
+
+	return nil
+}
+
+func (m CartModel) RemoveItem(userID, herbID int64) error {
+	query := `DELETE FROM cart_items
+		WHERE user_id = $1 AND herb_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, herbID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// RefreshPrices updates every item in the user's cart to the herb's current price, so
+// that the user can review the new prices before checking out again.
+func (m CartModel) RefreshPrices(userID int64) error {
+	query := `UPDATE cart_items
+		SET unit_price_amount = herbs.price_amount, unit_price_currency = herbs.price_currency
+		FROM herbs
+		WHERE cart_items.herb_id = herbs.id AND cart_items.user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// getCartItems returns the items in a user's cart, ordered by herb ID so that callers
+// locking the herbs always do so in the same order. If lock is true the cart rows are
+// locked FOR UPDATE until the transaction ends.
+func getCartItems(ctx context.Context, q dbtx, userID int64, lock bool) ([]*CartItem, error) {
+	query := `SELECT cart_items.herb_id, herbs.name, cart_items.quantity, cart_items.unit_price_amount, cart_items.unit_price_currency,
+			(herbs.price_amount <> cart_items.unit_price_amount OR herbs.price_currency <> cart_items.unit_price_currency),
+			herbs.price_amount, herbs.price_currency, herbs.culinary_uses
+		FROM cart_items
+		INNER JOIN herbs ON herbs.id = cart_items.herb_id
+		WHERE cart_items.user_id = $1 AND herbs.deleted_at IS NULL
+		ORDER BY cart_items.herb_id`
+
+	if lock {
+		query += ` FOR UPDATE OF cart_items`
+	}
+
+	rows, err := q.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*CartItem{}
+
+	for rows.Next() {
+		var item CartItem
+
+		err := rows.Scan(
+			&item.HerbID,
+			&item.HerbName,
+			&item.Quantity,
+			&item.UnitPrice.Amount,
+			&item.UnitPrice.Currency,
+			&item.PriceChanged,
+			&item.herb.Price.Amount,
+			&item.herb.Price.Currency,
+			pq.Array(&item.herb.CulinaryUses),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		item.herb.ID = item.HerbID
+		items = append(items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
@@ -0,0 +1,346 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+var (
+	ErrDuplicateSlug       = errors.New("duplicate slug")
+	ErrCategoryCycle       = errors.New("category cycle")
+	ErrCategoryHasChildren = errors.New("category has children")
+	ErrUnknownCategory     = errors.New("unknown category")
+)
+
+var SlugRX = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Category is a node in the catalog taxonomy, for example Spices > Seeds > Cumin. Root
+// categories have a ParentID of zero.
+type Category struct {
+	ID        int64       `json:"id"`
+	CreatedAt time.Time   `json:"-"`
+	Name      string      `json:"name"`
+	Slug      string      `json:"slug"`
+	ParentID  int64       `json:"parent_id,omitempty"`
+	Version   int32       `json:"version"`
+	Children  []*Category `json:"children,omitempty"` // Only set when returning the tree
+}
+
+func ValidateCategory(v *validator.Validator, category *Category) {
+	v.Check(category.Name != "", "name", "must be provided")
+	v.Check(len(category.Name) <= 200, "name", "must not be more than 200 bytes long")
+
+	v.Check(category.Slug != "", "slug", "must be provided")
+	v.Check(len(category.Slug) <= 200, "slug", "must not be more than 200 bytes long")
+	v.Check(validator.Matches(category.Slug, SlugRX), "slug", "must contain only lower case letters, digits and single hyphens")
+
+	v.Check(category.ParentID >= 0, "parent_id", "must be a valid category id")
+	v.Check(category.ID == 0 || category.ParentID != category.ID, "parent_id", "must not be the category itself")
+}
+
+// CategoryTree arranges a flat list of categories into a forest, returning the roots.
+func CategoryTree(categories []*Category) []*Category {
+	byID := make(map[int64]*Category, len(categories))
+	for _, category := range categories {
+		byID[category.ID] = category
+	}
+
+	roots := []*Category{}
+	for _, category := range categories {
+		parent, ok := byID[category.ParentID]
+		if !ok {
+			roots = append(roots, category)
+			continue
+		}
+		parent.Children = append(parent.Children, category)
+	}
+
+	return roots
+}
+
+type CategoryModel struct {
+	DB *sql.DB
+}
+
+func (m CategoryModel) Insert(category *Category) error {
+	query := `INSERT INTO categories (name, slug, parent_id)
+		VALUES ($1, $2, NULLIF($3, 0))
+		RETURNING id, created_at, version`
+
+	args := []interface{}{category.Name, category.Slug, category.ParentID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&category.ID, &category.CreatedAt, &category.Version)
+	if err != nil {
+		return categoryError(err)
+	}
+
+	return nil
+}
+
+func (m CategoryModel) Get(id int64) (*Category, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, created_at, name, slug, coalesce(parent_id, 0), version
+		FROM categories
+		WHERE id = $1`
+
+	var category Category
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&category.ID,
+		&category.CreatedAt,
+		&category.Name,
+		&category.Slug,
+		&category.ParentID,
+		&category.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &category, nil
+}
+
+func (m CategoryModel) GetAll() ([]*Category, error) {
+	query := `SELECT id, created_at, name, slug, coalesce(parent_id, 0), version
+		FROM categories
+		ORDER BY name, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanCategories(rows)
+}
+
+// GetAllForHerbs returns the categories each of the given herbs is directly linked to,
+// keyed by herb ID.
+func (m CategoryModel) GetAllForHerbs(herbIDs []int64) (map[int64][]*Category, error) {
+	query := `SELECT herbs_categories.herb_id, categories.id, categories.created_at, categories.name,
+			categories.slug, coalesce(categories.parent_id, 0), categories.version
+		FROM categories
+		INNER JOIN herbs_categories ON herbs_categories.category_id = categories.id
+		WHERE herbs_categories.herb_id = ANY($1)
+		ORDER BY categories.name, categories.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(herbIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := make(map[int64][]*Category)
+
+	for rows.Next() {
+		var herbID int64
+		var category Category
+
+		err := rows.Scan(
+			&herbID,
+			&category.ID,
+			&category.CreatedAt,
+			&category.Name,
+			&category.Slug,
+			&category.ParentID,
+			&category.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		categories[herbID] = append(categories[herbID], &category)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return categories, nil
+}
+
+// Update saves changes to a category. Moving a category underneath itself or one of its
+// own descendants would create a cycle, so that is rejected with ErrCategoryCycle.
+func (m CategoryModel) Update(category *Category) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if category.ParentID != 0 {
+		query := `WITH RECURSIVE subtree AS (
+				SELECT id FROM categories WHERE id = $1
+				UNION ALL
+				SELECT categories.id FROM categories INNER JOIN subtree ON categories.parent_id = subtree.id
+			)
+			SELECT EXISTS (SELECT 1 FROM subtree WHERE id = $2)`
+
+		var cycle bool
+
+		err = tx.QueryRowContext(ctx, query, category.ID, category.ParentID).Scan(&cycle)
+		if err != nil {
+			return err
+		}
+
+		if cycle {
+			return ErrCategoryCycle
+		}
+	}
+
+	query := `UPDATE categories
+		SET name = $1, slug = $2, parent_id = NULLIF($3, 0), version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+
+	args := []interface{}{category.Name, category.Slug, category.ParentID, category.ID, category.Version}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&category.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return categoryError(err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m CategoryModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM categories
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return categoryError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// SetForHerb replaces the categories a herb is linked to. If any of the category IDs
+// don't exist nothing is changed and ErrUnknownCategory is returned.
+func (m CategoryModel) SetForHerb(herbID int64, categoryIDs []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM herbs_categories WHERE herb_id = $1`, herbID)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO herbs_categories (herb_id, category_id)
+		SELECT $1, categories.id FROM categories WHERE categories.id = ANY($2)`
+
+	result, err := tx.ExecContext(ctx, query, herbID, pq.Array(categoryIDs))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != int64(len(categoryIDs)) {
+		return ErrUnknownCategory
+	}
+
+	return tx.Commit()
+}
+
+func scanCategories(rows *sql.Rows) ([]*Category, error) {
+	categories := []*Category{}
+
+	for rows.Next() {
+		var category Category
+
+		err := rows.Scan(
+			&category.ID,
+			&category.CreatedAt,
+			&category.Name,
+			&category.Slug,
+			&category.ParentID,
+			&category.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		categories = append(categories, &category)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return categories, nil
+}
+
+// categoryError maps constraint violations on the categories table to our own errors.
+func categoryError(err error) error {
+	switch {
+	case err.Error() == `pq: duplicate key value violates unique constraint "categories_slug_key"`:
+		return ErrDuplicateSlug
+	case err.Error() == `pq: insert or update on table "categories" violates foreign key constraint "categories_parent_id_fkey"`:
+		return ErrUnknownCategory
+	case err.Error() == `pq: update or delete on table "categories" violates foreign key constraint "categories_parent_id_fkey" on table "categories"`:
+		return ErrCategoryHasChildren
+	default:
+		return err
+	}
+}
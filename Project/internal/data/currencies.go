@@ -0,0 +1,74 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Currency is an ISO 4217 currency we accept prices in. MinorUnits is the number of
+// digits after the decimal separator in its minor unit.
+type Currency struct {
+	Code       string `json:"code"`
+	Name       string `json:"name"`
+	MinorUnits int    `json:"minor_units"`
+}
+
+type CurrencyModel struct {
+	DB *sql.DB
+}
+
+func (m CurrencyModel) GetAll() ([]*Currency, error) {
+	query := `SELECT code, name, minor_units
+		FROM currencies
+		ORDER BY code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	currencies := []*Currency{}
+
+	for rows.Next() {
+		var currency Currency
+
+		err := rows.Scan(&currency.Code, &currency.Name, &currency.MinorUnits)
+		if err != nil {
+			return nil, err
+		}
+
+		currencies = append(currencies, &currency)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return currencies, nil
+}
+
+// Load reads the currencies table and makes its currencies the ones prices can be
+// parsed, formatted and validated in. It has to be called at startup, before any prices
+// are handled.
+func (m CurrencyModel) Load() error {
+	currencies, err := m.GetAll()
+	if err != nil {
+		return err
+	}
+
+	exponents := make(map[string]int, len(currencies))
+	for _, currency := range currencies {
+		exponents[currency.Code] = currency.MinorUnits
+	}
+
+	currencyExponents.Lock()
+	currencyExponents.m = exponents
+	currencyExponents.Unlock()
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"time"
+
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+var (
+	ErrExchangeRateNotFound = errors.New("exchange rate not found")
+)
+
+// RateRX matches the decimal exchange rates that fit the exchange_rates.rate column.
+var RateRX = regexp.MustCompile(`^[0-9]{1,10}(\.[0-9]{1,10})?$`)
+
+// ExchangeRate records how many units of the Quote currency one unit of the Base
+// currency buys. Rate is kept as a decimal string so that it round-trips exactly.
+type ExchangeRate struct {
+	Base      string    `json:"base"`
+	Quote     string    `json:"quote"`
+	Rate      string    `json:"rate"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func ValidateExchangeRate(v *validator.Validator, key string, rate *ExchangeRate) {
+	v.Check(SupportedCurrency(rate.Base), key+".base", "must be a supported currency")
+	v.Check(SupportedCurrency(rate.Quote), key+".quote", "must be a supported currency")
+	v.Check(rate.Base != rate.Quote, key+".quote", "must differ from base")
+
+	v.Check(rate.Rate != "", key+".rate", "must be provided")
+	v.Check(validator.Matches(rate.Rate, RateRX), key+".rate", "must be a decimal number with at most 10 digits either side of the point")
+
+	if r, ok := new(big.Rat).SetString(rate.Rate); ok {
+		v.Check(r.Sign() > 0, key+".rate", "must be greater than zero")
+	}
+}
+
+// Converter converts prices into a single target currency using the exchange rates
+// that were loaded when it was created.
+type Converter struct {
+	Currency string
+	rates    map[string]*big.Rat
+}
+
+// Convert returns p expressed in the converter's currency. Prices which are already in
+// that currency are returned unchanged.
+func (c Converter) Convert(p Price) (Price, error) {
+	if p.Currency == c.Currency {
+		return p, nil
+	}
+
+	rate, ok := c.rates[p.Currency]
+	if !ok {
+		return Price{}, fmt.Errorf("%w: %s to %s", ErrExchangeRateNotFound, p.Currency, c.Currency)
+	}
+
+	return p.Convert(c.Currency, rate)
+}
+
+type ExchangeRateModel struct {
+	DB *sql.DB
+}
+
+func (m ExchangeRateModel) GetAll() ([]*ExchangeRate, error) {
+	query := `SELECT base_currency, quote_currency, rate, updated_at
+		FROM exchange_rates
+		ORDER BY base_currency, quote_currency`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []*ExchangeRate{}
+
+	for rows.Next() {
+		var rate ExchangeRate
+
+		err := rows.Scan(&rate.Base, &rate.Quote, &rate.Rate, &rate.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		rates = append(rates, &rate)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rates, nil
+}
+
+// Upsert stores the given rates in a single transaction, replacing any existing rate for
+// the same currency pair.
+func (m ExchangeRateModel) Upsert(rates []*ExchangeRate) error {
+	query := `INSERT INTO exchange_rates (base_currency, quote_currency, rate)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (base_currency, quote_currency)
+		DO UPDATE SET rate = EXCLUDED.rate, updated_at = NOW()
+		RETURNING updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, rate := range rates {
+		err = tx.QueryRowContext(ctx, query, rate.Base, rate.Quote, rate.Rate).Scan(&rate.UpdatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Converter loads every rate involving the given currency. Direct rates into the
+// currency are preferred; otherwise the inverse of a rate out of it is used.
+func (m ExchangeRateModel) Converter(currency string) (*Converter, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return loadConverter(ctx, m.DB, currency)
+}
+
+func loadConverter(ctx context.Context, q dbtx, currency string) (*Converter, error) {
+	query := `SELECT base_currency, quote_currency, rate
+		FROM exchange_rates
+		WHERE base_currency = $1 OR quote_currency = $1
+		ORDER BY quote_currency = $1`
+
+	rows, err := q.QueryContext(ctx, query, currency)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	converter := &Converter{
+		Currency: currency,
+		rates:    make(map[string]*big.Rat),
+	}
+
+	for rows.Next() {
+		var base, quote, value string
+
+		err := rows.Scan(&base, &quote, &value)
+		if err != nil {
+			return nil, err
+		}
+
+		rate, ok := new(big.Rat).SetString(value)
+		if !ok || rate.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid exchange rate %q for %s/%s", value, base, quote)
+		}
+
+		// Rows with the currency as the quote sort last, so they overwrite any inverse
+		// rate recorded for the same pair.
+		if quote == currency {
+			converter.rates[base] = rate
+		} else {
+			converter.rates[quote] = rate.Inv(rate)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return converter, nil
+}
@@ -0,0 +1,164 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// HerbFacets are the facets which can be counted over a herb listing.
+var HerbFacets = []string{"culinary_uses", "price_range", "category"}
+
+// priceBands are the price_range facet's buckets, as lower bounds in the minor units of
+// DefaultCurrency. Each band runs up to the next one's lower bound, and the last one
+// has no upper bound.
+var priceBands = []int64{0, 500, 1000, 2500, 5000}
+
+// FacetBucket is the number of matching herbs with one value of a facet. Culinary uses
+// have a Value, price bands a Value along with their Min and Max prices, and categories
+// an ID and Name.
+type FacetBucket struct {
+	Value string `json:"value,omitempty"`
+	ID    int64  `json:"id,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Min   *Price `json:"min,omitempty"`
+	Max   *Price `json:"max,omitempty"`
+	Count int    `json:"count"`
+}
+
+// Facets maps facet names to their buckets.
+type Facets map[string][]*FacetBucket
+
+// Facets counts the herbs matching filter by each of the named facets, all in one
+// query. Prices are converted to DefaultCurrency to be sorted into price bands.
+func (h HerbModel) Facets(filter HerbFilter, names []string) (Facets, error) {
+	// Every facet comes back as (facet, value, number, count) rows: the culinary use, the
+	// currency and amount of a price, or the name and ID of a category.
+	query := fmt.Sprintf(`WITH matches AS (
+			SELECT id, price_amount, price_currency, culinary_uses
+			FROM herbs
+			WHERE %s
+		)
+		SELECT 'culinary_uses', use, 0, count(*)
+		FROM matches, unnest(matches.culinary_uses) AS use
+		WHERE 'culinary_uses' = ANY($5::text[])
+		GROUP BY use
+		UNION ALL
+		SELECT 'price_range', price_currency, price_amount, count(*)
+		FROM matches
+		WHERE 'price_range' = ANY($5::text[])
+		GROUP BY price_currency, price_amount
+		UNION ALL
+		SELECT 'category', categories.name, categories.id, count(*)
+		FROM matches
+		INNER JOIN herbs_categories ON herbs_categories.herb_id = matches.id
+		INNER JOIN categories ON categories.id = herbs_categories.category_id
+		WHERE 'category' = ANY($5::text[])
+		GROUP BY categories.id, categories.name`, herbFilterCondition)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := append(filter.args(), pq.Array(names))
+
+	rows, err := h.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	facets := make(Facets)
+	prices := make(map[Price]int)
+
+	for _, name := range names {
+		facets[name] = []*FacetBucket{}
+	}
+
+	for rows.Next() {
+		var (
+			facet, value string
+			number       int64
+			count        int
+		)
+
+		err := rows.Scan(&facet, &value, &number, &count)
+		if err != nil {
+			return nil, err
+		}
+
+		switch facet {
+		case "culinary_uses":
+			facets[facet] = append(facets[facet], &FacetBucket{Value: value, Count: count})
+		case "price_range":
+			prices[Price{Amount: number, Currency: value}] += count
+		case "category":
+			facets[facet] = append(facets[facet], &FacetBucket{ID: number, Name: value, Count: count})
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, ok := facets["price_range"]; ok {
+		facets["price_range"], err = priceRangeBuckets(ctx, h.DB, prices)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range []string{"culinary_uses", "category"} {
+		buckets := facets[name]
+		sort.Slice(buckets, func(i, j int) bool {
+			if buckets[i].Count != buckets[j].Count {
+				return buckets[i].Count > buckets[j].Count
+			}
+			// Only one of Value and Name is set, depending on the facet.
+			return buckets[i].Value+buckets[i].Name < buckets[j].Value+buckets[j].Name
+		})
+	}
+
+	return facets, nil
+}
+
+// priceRangeBuckets sorts the counts of herbs at each price into the price bands.
+// Every band is returned, even if no herb falls into it.
+func priceRangeBuckets(ctx context.Context, q dbtx, prices map[Price]int) ([]*FacetBucket, error) {
+	converter, err := loadConverter(ctx, q, DefaultCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]*FacetBucket, len(priceBands))
+	for i, lower := range priceBands {
+		bucket := &FacetBucket{Min: &Price{Amount: lower, Currency: DefaultCurrency}}
+
+		if i+1 < len(priceBands) {
+			bucket.Max = &Price{Amount: priceBands[i+1], Currency: DefaultCurrency}
+			bucket.Value = fmt.Sprintf("%d-%d", lower/100, priceBands[i+1]/100)
+		} else {
+			bucket.Value = fmt.Sprintf("%d-", lower/100)
+		}
+
+		buckets[i] = bucket
+	}
+
+	for price, count := range prices {
+		converted, err := converter.Convert(price)
+		if err != nil {
+			return nil, err
+		}
+
+		i := sort.Search(len(priceBands), func(i int) bool { return priceBands[i] > converted.Amount }) - 1
+		if i < 0 {
+			i = 0
+		}
+
+		buckets[i].Count += count
+	}
+
+	return buckets, nil
+}
@@ -1,7 +1,11 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"math"
+	"strconv"
 	"strings"
 
 	"gourmetspices.yerassyl.net/internal/validator"
@@ -12,14 +16,76 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	Cursor       string // next_cursor from a previous page, for listings which support it
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+}
+
+// cursor is the decoded form of a next_cursor token. It holds the sort it was issued
+// for and the sort key and ID of the last record on the page, and the next page starts
+// after that record. Tokens are opaque to clients.
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeCursor(token string) (cursor, error) {
+	var c cursor
+
+	js, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return cursor{}, err
+	}
+
+	err = json.Unmarshal(js, &c)
+	if err != nil {
+		return cursor{}, err
+	}
+
+	return c, nil
+}
+
+// validCursorValue reports whether a cursor's sort key value has the type of the column
+// it is compared with, so that a tampered cursor is turned away rather than failing in
+// PostgreSQL.
+func validCursorValue(sort, value string) bool {
+	switch strings.TrimPrefix(sort, "-") {
+	case "id", "price":
+		_, err := strconv.ParseInt(value, 10, 64)
+		return err == nil
+	case "rating", "relevance":
+		// ParseFloat also takes hexadecimal floats, which PostgreSQL doesn't.
+		f, err := strconv.ParseFloat(value, 64)
+		return err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) && !strings.ContainsAny(value, "xX")
+	default:
+		return true
+	}
+}
+
+// keyset returns the condition selecting the records after a cursor, for a listing
+// ordered by column in the given direction and then by ascending ID. The cursor's sort
+// key and ID are the placeholders valueArg and idArg. The key is sent as text, which
+// PostgreSQL reads as the column's type.
+func keyset(column, direction string, valueArg, idArg int) string {
+	op := ">"
+	if direction == "DESC" {
+		op = "<"
+	}
+
+	return fmt.Sprintf("(%[1]s %[2]s $%[3]d OR (%[1]s = $%[3]d AND id > $%[4]d))", column, op, valueArg, idArg)
 }
 
 func (f Filters) sortColumn() string {
@@ -52,6 +118,13 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		v.Check(err == nil && c.ID > 0 && validCursorValue(c.Sort, c.Value), "cursor", "must be a next_cursor value from a previous page")
+		v.Check(err != nil || c.Sort == f.Sort, "cursor", "must be used with the sort it was issued for")
+		v.Check(f.Page == 1, "page", "must not be used with cursor")
+	}
 }
 
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
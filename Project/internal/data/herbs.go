@@ -6,20 +6,37 @@ import (
 	"errors"
 	"fmt"
 	"github.com/lib/pq"
+	"strconv"
+	"strings"
 	"time"
 
 	"gourmetspices.yerassyl.net/internal/validator"
 )
 
+var (
+	ErrUnknownHerb = errors.New("unknown herb")
+)
+
 type Herb struct {
-	ID           int64     `json:"id"`                      // Unique integer ID for the movie
-	CreatedAt    time.Time `json:"-"`                       // Timestamp for when the movie is added to our database
-	Name         string    `json:"name"`                    // Herb name
-	Description  string    `json:"description,omitempty"`   // Herb description
-	Price        Price     `json:"price"`                   // Herb price
-	CulinaryUses []string  `json:"culinary_uses,omitempty"` // Culinary uses of Herb
-	Version      int32     `json:"version"`                 // The version number starts at 1 and will be incremented
+	ID            int64     `json:"id"`                      // Unique integer ID for the movie
+	CreatedAt     time.Time `json:"-"`                       // Timestamp for when the movie is added to our database
+	Name          string    `json:"name"`                    // Herb name
+	Description   string    `json:"description,omitempty"`   // Herb description
+	Price         Price     `json:"price"`                   // Herb price
+	SalePrice     *Price    `json:"sale_price,omitempty"`    // Price after the best running sale, if there is one
+	CulinaryUses  []string  `json:"culinary_uses,omitempty"` // Culinary uses of Herb
+	StockQuantity int64     `json:"stock_quantity"`          // Stock on hand, only changed through stock movements
+	StockUnit     string    `json:"stock_unit"`              // Unit the stock is counted in (grams, jars)
+	AverageRating float64   `json:"average_rating"`          // Mean of the visible review ratings, 0 if there are none
+	Snippet       string    `json:"snippet,omitempty"`       // Description with the search terms highlighted, when searching
+	Relevance     float64   `json:"-"`                       // How well the herb matches the search, when searching
+	ReviewCount   int32     `json:"review_count"`            // Number of visible reviews
+	Version       int32     `json:"version"`                 // The version number starts at 1 and will be incremented
 	// each time the herb information is updated
+	DeletedAt  *time.Time     `json:"deleted_at,omitempty"` // When the herb was moved to the trash, only set on herbs in the trash
+	Variants   []*HerbVariant `json:"variants,omitempty"`   // Packs the herb is sold in, when requested
+	Categories []*Category    `json:"categories,omitempty"` // Categories the herb is filed under, when requested
+	Images     []*HerbImage   `json:"images,omitempty"`     // Pictures of the herb in display order
 }
 
 func ValidateHerb(v *validator.Validator, herb *Herb) {
@@ -29,64 +46,178 @@ func ValidateHerb(v *validator.Validator, herb *Herb) {
 	v.Check(herb.Description != "", "description", "must be provided")
 	v.Check(len(herb.Description) <= 500, "description", "must not be more than 500 bytes long")
 
-	v.Check(herb.Price != 0, "price", "must be provided")
-	v.Check(herb.Price >= 0, "price", "must be a positive integer")
+	ValidatePrice(v, "price", herb.Price)
 
 	v.Check(herb.CulinaryUses != nil, "culinary_uses", "must be provided")
 	v.Check(len(herb.CulinaryUses) >= 1, "culinary_uses", "must contain at least 1 use")
 	v.Check(len(herb.CulinaryUses) <= 5, "culinary_uses", "must not contain more than 5 uses")
 	v.Check(validator.Unique(herb.CulinaryUses), "culinary_uses", "must not contain duplicate values")
+
+	v.Check(validator.In(herb.StockUnit, StockUnits...), "stock_unit", "must be one of grams, jars")
+}
+
+// herbSortColumns maps the sort keys accepted by GetAll onto the herbs table columns
+// they order by, where the two differ.
+var herbSortColumns = map[string]string{
+	"price":     "price_amount",
+	"rating":    "average_rating",
+	"relevance": "relevance",
+}
+
+func herbSortColumn(key string) string {
+	if column, ok := herbSortColumns[key]; ok {
+		return column
+	}
+	return key
 }
 
 type HerbModel struct {
 	DB *sql.DB
 }
 
-func (h HerbModel) Insert(herb *Herb) error {
+// Insert adds the herb, recording it in the audit trail against actor.
+func (h HerbModel) Insert(herb *Herb, actor Actor) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertHerb(ctx, tx, herb, actor)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertHerb adds the herb and records it in the audit trail, as one step of the
+// transaction tx.
+func insertHerb(ctx context.Context, tx *sql.Tx, herb *Herb, actor Actor) error {
 
-	query := `INSERT INTO herbs (name, description, price, culinary_uses) 
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, created_at, version`
+	query := `INSERT INTO herbs (name, description, price_amount, price_currency, culinary_uses, stock_unit)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, stock_quantity, version`
 
 	args := []interface{}{
 		herb.Name,
 		herb.Description,
-		herb.Price,
+		herb.Price.Amount,
+		herb.Price.Currency,
 		pq.Array(herb.CulinaryUses),
+		herb.StockUnit,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	return h.DB.QueryRowContext(ctx, query, args...).Scan(
+	err := tx.QueryRowContext(ctx, query, args...).Scan(
 		&herb.ID,
 		&herb.CreatedAt,
+		&herb.StockQuantity,
 		&herb.Version,
 	)
+	if err != nil {
+		return err
+	}
+
+	return insertAuditEvent(ctx, tx, actor, "create", "herb", herb.ID, nil, herb)
+}
+
+// InsertMany inserts all of the herbs in a single transaction, so that either every
+// herb is added or none are. It is meant for imports, which may be large enough to
+// need more than the usual 3 seconds. Each herb is recorded in the audit trail against
+// actor.
+func (h HerbModel) InsertMany(herbs []*Herb, actor Actor) error {
+	query := `INSERT INTO herbs (name, description, price_amount, price_currency, culinary_uses, stock_unit)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, stock_quantity, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, herb := range herbs {
+		args := []interface{}{
+			herb.Name,
+			herb.Description,
+			herb.Price.Amount,
+			herb.Price.Currency,
+			pq.Array(herb.CulinaryUses),
+			herb.StockUnit,
+		}
+
+		err = stmt.QueryRowContext(ctx, args...).Scan(
+			&herb.ID,
+			&herb.CreatedAt,
+			&herb.StockQuantity,
+			&herb.Version,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = insertAuditEvent(ctx, tx, actor, "create", "herb", herb.ID, nil, herb)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 func (h HerbModel) Get(id int64) (*Herb, error) {
 
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getHerb(ctx, h.DB, id, false)
+}
+
+// getHerb fetches a herb using q, which may be a transaction. If lock is true the row
+// is locked FOR UPDATE until the transaction ends, so that callers can rely on the
+// herb's price, stock and version not changing underneath them. Herbs in the trash
+// aren't found.
+func getHerb(ctx context.Context, q dbtx, id int64, lock bool) (*Herb, error) {
+
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
-	query := `SELECT id, created_at, name, description, price, culinary_uses, version
+	query := `SELECT id, created_at, name, description, price_amount, price_currency, culinary_uses, stock_quantity, stock_unit, average_rating, review_count, version
 		FROM herbs
-		WHERE id = $1`
+		WHERE id = $1 AND deleted_at IS NULL`
 
-	var herb Herb
+	if lock {
+		query += ` FOR UPDATE`
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	var herb Herb
 
-	err := h.DB.QueryRowContext(ctx, query, id).Scan(
+	err := q.QueryRowContext(ctx, query, id).Scan(
 		&herb.ID,
 		&herb.CreatedAt,
 		&herb.Name,
 		&herb.Description,
-		&herb.Price,
+		&herb.Price.Amount,
+		&herb.Price.Currency,
 		pq.Array(&herb.CulinaryUses),
+		&herb.StockQuantity,
+		&herb.StockUnit,
+		&herb.AverageRating,
+		&herb.ReviewCount,
 		&herb.Version,
 	)
 
@@ -103,20 +234,198 @@ func (h HerbModel) Get(id int64) (*Herb, error) {
 	return &herb, nil
 }
 
-func (h HerbModel) GetAll(name string, culinaryUses []string, filters Filters) ([]*Herb, Metadata, error) {
+// HerbFilter narrows down the herbs returned by GetAll and Export. The zero value
+// matches every herb that isn't in the trash.
+type HerbFilter struct {
+	Name         string   // Words which must all appear in the name
+	CulinaryUses []string // Uses the herb must have every one of
+	CategoryID   int64    // Category the herb is filed under, directly or through a subcategory
+	Search       string   // Free text matched against the name, description and culinary uses
+}
 
-	query := fmt.Sprintf(`SELECT count(*) OVER(), id, created_at, name, description, price, culinary_uses, version
-		FROM herbs
-		WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+// herbFilterCondition is the WHERE condition for a HerbFilter. It takes the filter's
+// args() as $1 to $4. The search matches either the full text of the herb or, to catch
+// misspellings such as "tumeric", a name or description word with enough trigrams in
+// common with it. Herbs in the trash never match.
+const herbFilterCondition = `deleted_at IS NULL
+		AND (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
 		AND (culinary_uses @> $2 OR $2 = '{}')
+		AND (id IN (
+			SELECT herbs_categories.herb_id FROM herbs_categories WHERE herbs_categories.category_id IN (
+				WITH RECURSIVE subtree AS (
+					SELECT id FROM categories WHERE id = $3
+					UNION ALL
+					SELECT categories.id FROM categories INNER JOIN subtree ON categories.parent_id = subtree.id
+				)
+				SELECT id FROM subtree
+			)
+		) OR $3 = 0)
+		AND (search_document @@ websearch_to_tsquery('english', $4) OR $4 <% name OR $4 <% description OR $4 = '')`
+
+// herbRelevance is how well a herb matches the search in $4. It adds the full text rank
+// to the trigram similarity of the name and, counting for less, the description.
+const herbRelevance = `CASE WHEN $4 = '' THEN 0
+			ELSE ts_rank(search_document, websearch_to_tsquery('english', $4)) + word_similarity($4, name) + word_similarity($4, description) / 2
+		END`
+
+// herbSnippet is the description of a herb with the words matching the search in $4
+// highlighted.
+const herbSnippet = `CASE WHEN $4 = '' THEN ''
+			ELSE ts_headline('english', description, websearch_to_tsquery('english', $4), 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5')
+		END`
+
+// HerbFields are the fields of a herb which GetAll can be asked to read with a fieldset.
+var HerbFields = []string{"id", "name", "description", "price", "sale_price", "culinary_uses", "stock_quantity", "stock_unit", "average_rating", "review_count", "version", "snippet"}
+
+// herbColumns are the columns GetAll can select, in the order they are selected, along
+// with where each one is scanned to.
+var herbColumns = []struct {
+	name string
+	expr string
+	dest func(herb *Herb) interface{}
+}{
+	{"id", "id", func(herb *Herb) interface{} { return &herb.ID }},
+	{"name", "name", func(herb *Herb) interface{} { return &herb.Name }},
+	{"description", "description", func(herb *Herb) interface{} { return &herb.Description }},
+	{"price_amount", "price_amount", func(herb *Herb) interface{} { return &herb.Price.Amount }},
+	{"price_currency", "price_currency", func(herb *Herb) interface{} { return &herb.Price.Currency }},
+	{"culinary_uses", "culinary_uses", func(herb *Herb) interface{} { return pq.Array(&herb.CulinaryUses) }},
+	{"stock_quantity", "stock_quantity", func(herb *Herb) interface{} { return &herb.StockQuantity }},
+	{"stock_unit", "stock_unit", func(herb *Herb) interface{} { return &herb.StockUnit }},
+	{"average_rating", "average_rating", func(herb *Herb) interface{} { return &herb.AverageRating }},
+	{"review_count", "review_count", func(herb *Herb) interface{} { return &herb.ReviewCount }},
+	{"version", "version", func(herb *Herb) interface{} { return &herb.Version }},
+	{"relevance", herbRelevance + " AS relevance", func(herb *Herb) interface{} { return &herb.Relevance }},
+	{"snippet", herbSnippet + " AS snippet", func(herb *Herb) interface{} { return &herb.Snippet }},
+}
+
+// herbFieldColumns maps each of the HerbFields, and the relevance used for sorting, onto
+// the columns needed to fill it in. Working out the sale price needs the herb's price
+// and culinary uses, since sales can be limited to particular uses.
+var herbFieldColumns = map[string][]string{
+	"id":             {"id"},
+	"name":           {"name"},
+	"description":    {"description"},
+	"price":          {"price_amount", "price_currency"},
+	"sale_price":     {"price_amount", "price_currency", "culinary_uses"},
+	"culinary_uses":  {"culinary_uses"},
+	"stock_quantity": {"stock_quantity"},
+	"stock_unit":     {"stock_unit"},
+	"average_rating": {"average_rating"},
+	"review_count":   {"review_count"},
+	"version":        {"version"},
+	"snippet":        {"snippet"},
+	"relevance":      {"relevance"},
+}
+
+// herbSortFields maps the sort keys accepted by GetAll onto the fields they read.
+var herbSortFields = map[string]string{
+	"id":          "id",
+	"name":        "name",
+	"description": "description",
+	"price":       "price",
+	"rating":      "average_rating",
+	"relevance":   "relevance",
+}
+
+// selectHerbColumns returns the select list for the given fields, and a function giving
+// the scan destinations for a herb in the same order. The ID and the sort key's field
+// are always selected, since relations and cursors need them.
+func selectHerbColumns(fields []string, sortKey string) (string, func(herb *Herb) []interface{}) {
+	if len(fields) == 0 {
+		fields = HerbFields
+	}
+
+	needed := make(map[string]bool)
+	for _, field := range append([]string{"id", herbSortFields[sortKey]}, fields...) {
+		for _, column := range herbFieldColumns[field] {
+			needed[column] = true
+		}
+	}
+
+	var exprs []string
+	var dests []func(herb *Herb) interface{}
+
+	for _, column := range herbColumns {
+		if needed[column.name] {
+			exprs = append(exprs, column.expr)
+			dests = append(dests, column.dest)
+		}
+	}
+
+	scan := func(herb *Herb) []interface{} {
+		values := make([]interface{}, len(dests))
+		for i, dest := range dests {
+			values[i] = dest(herb)
+		}
+		return values
+	}
+
+	return strings.Join(exprs, ", "), scan
+}
+
+func (f HerbFilter) args() []interface{} {
+	culinaryUses := f.CulinaryUses
+	if culinaryUses == nil {
+		culinaryUses = []string{}
+	}
+
+	return []interface{}{f.Name, pq.Array(culinaryUses), f.CategoryID, f.Search}
+}
+
+// GetAll returns a page of the herbs matching filter. Sorting by relevance puts the best
+// matches for the search first.
+//
+// Pages can be given by number, or by the cursor from the previous page's next_cursor.
+// A cursor page starts straight after the last herb of the previous one, which is
+// found through the sort key rather than counted with OFFSET, so it stays fast on any
+// page and doesn't skip or repeat herbs when others are added or removed in between.
+// Cursor pages don't have page numbers or a total.
+//
+// Only the columns needed for the given HerbFields are read, along with the ID; the rest
+// of each herb is left at its zero value. With no fields, every field is read.
+func (h HerbModel) GetAll(filter HerbFilter, fields []string, filters Filters) ([]*Herb, Metadata, error) {
+
+	sortKey := filters.sortColumn()
+	column := herbSortColumn(sortKey)
+	direction := filters.sortDirection()
+	if sortKey == "relevance" {
+		direction = "DESC"
+	}
+
+	args := append(filter.args(), filters.limit(), filters.offset())
+	after := "TRUE"
+
+	if filters.Cursor != "" {
+		c, err := decodeCursor(filters.Cursor)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		// relevance is only a column alias, which can't be used in WHERE.
+		keyColumn := column
+		if sortKey == "relevance" {
+			keyColumn = herbRelevance
+		}
+
+		// Fetch one herb more than the page holds, to find out whether there is a next
+		// page.
+		args = append(filter.args(), filters.limit()+1, 0, c.Value, c.ID)
+		after = keyset(keyColumn, direction, 7, 8)
+	}
+
+	columns, scan := selectHerbColumns(fields, sortKey)
+
+	query := fmt.Sprintf(`SELECT count(*) OVER(), %s
+		FROM herbs
+		WHERE %s
+		AND %s
 		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+		LIMIT $5 OFFSET $6`, columns, herbFilterCondition, after, column, direction)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []interface{}{name, pq.Array(culinaryUses), filters.limit(), filters.offset()}
-
 	rows, err := h.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
@@ -130,52 +439,226 @@ func (h HerbModel) GetAll(name string, culinaryUses []string, filters Filters) (
 
 		var herb Herb
 
+		err := rows.Scan(append([]interface{}{&totalRecords}, scan(&herb)...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		herbs = append(herbs, &herb)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	var metadata Metadata
+
+	if filters.Cursor != "" {
+		metadata.PageSize = filters.PageSize
+		if len(herbs) > filters.PageSize {
+			herbs = herbs[:filters.PageSize]
+			metadata.NextCursor, err = herbNextCursor(sortKey, filters.Sort, herbs[len(herbs)-1])
+			if err != nil {
+				return nil, Metadata{}, err
+			}
+		}
+	} else {
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+		if metadata.CurrentPage < metadata.LastPage && len(herbs) > 0 {
+			metadata.NextCursor, err = herbNextCursor(sortKey, filters.Sort, herbs[len(herbs)-1])
+			if err != nil {
+				return nil, Metadata{}, err
+			}
+		}
+	}
+
+	return herbs, metadata, nil
+}
+
+// herbNextCursor returns the cursor for the page after herb, in a listing sorted by
+// sortKey. It returns an error for sort keys which have no cursor value.
+func herbNextCursor(sortKey, sort string, herb *Herb) (string, error) {
+	var value string
+
+	switch sortKey {
+	case "id":
+		value = strconv.FormatInt(herb.ID, 10)
+	case "name":
+		value = herb.Name
+	case "description":
+		value = herb.Description
+	case "price":
+		value = strconv.FormatInt(herb.Price.Amount, 10)
+	case "rating":
+		value = strconv.FormatFloat(herb.AverageRating, 'f', -1, 64)
+	case "relevance":
+		value = strconv.FormatFloat(herb.Relevance, 'g', -1, 64)
+	default:
+		return "", fmt.Errorf("no cursor value for sort key %q", sortKey)
+	}
+
+	return encodeCursor(cursor{Sort: sort, Value: value, ID: herb.ID}), nil
+}
+
+// herbCursorBatchSize is the number of herbs a HerbCursor fetches at a time.
+const herbCursorBatchSize = 500
+
+// HerbCursor streams herbs out of a PostgreSQL cursor. They are fetched in batches, so
+// only one batch is held in memory however many herbs there are. Close must be called
+// when done.
+type HerbCursor struct {
+	ctx   context.Context
+	tx    *sql.Tx
+	batch []*Herb
+	done  bool
+}
+
+// Export opens a cursor over every herb matching filter, in ID order. The cursor lives in
+// a read-only transaction, so it sees the herbs as they were when it was opened however
+// long reading them takes. It is bound to ctx rather than the usual 3 second timeout.
+func (h HerbModel) Export(ctx context.Context, filter HerbFilter) (*HerbCursor, error) {
+	query := fmt.Sprintf(`DECLARE herb_export NO SCROLL CURSOR FOR
+		SELECT id, created_at, name, description, price_amount, price_currency, culinary_uses, stock_quantity, stock_unit, average_rating, review_count, version
+		FROM herbs
+		WHERE %s
+		ORDER BY id`, herbFilterCondition)
+
+	tx, err := h.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, query, filter.args()...)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return &HerbCursor{ctx: ctx, tx: tx}, nil
+}
+
+// Next returns the next herb, or nil once every herb has been read.
+func (c *HerbCursor) Next() (*Herb, error) {
+	if len(c.batch) == 0 && !c.done {
+		err := c.fetch()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(c.batch) == 0 {
+		return nil, nil
+	}
+
+	herb := c.batch[0]
+	c.batch = c.batch[1:]
+
+	return herb, nil
+}
+
+func (c *HerbCursor) fetch() error {
+	rows, err := c.tx.QueryContext(c.ctx, fmt.Sprintf("FETCH %d FROM herb_export", herbCursorBatchSize))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	c.batch = make([]*Herb, 0, herbCursorBatchSize)
+
+	for rows.Next() {
+		var herb Herb
+
 		err := rows.Scan(
-			&totalRecords,
 			&herb.ID,
 			&herb.CreatedAt,
 			&herb.Name,
 			&herb.Description,
-			&herb.Price,
+			&herb.Price.Amount,
+			&herb.Price.Currency,
 			pq.Array(&herb.CulinaryUses),
+			&herb.StockQuantity,
+			&herb.StockUnit,
+			&herb.AverageRating,
+			&herb.ReviewCount,
 			&herb.Version,
 		)
 		if err != nil {
-			return nil, Metadata{}, err
+			return err
 		}
 
-		herbs = append(herbs, &herb)
+		c.batch = append(c.batch, &herb)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err
+		return err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	c.done = len(c.batch) < herbCursorBatchSize
 
-	return herbs, metadata, nil
+	return nil
+}
+
+// Close closes the cursor and ends its transaction.
+func (c *HerbCursor) Close() error {
+	err := c.tx.Rollback()
+	if errors.Is(err, sql.ErrTxDone) {
+		return nil
+	}
+	return err
+}
+
+// Update saves the herb's details. The changes are recorded in the audit trail against
+// actor in the same transaction and, if the price has changed, the old and new prices
+// are recorded in the herb's price history as well.
+func (h HerbModel) Update(herb *Herb, actor Actor) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateHerb(ctx, tx, herb, actor)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (h HerbModel) Update(herb *Herb) error {
+// updateHerb is Update as one step of the transaction tx.
+func updateHerb(ctx context.Context, tx *sql.Tx, herb *Herb, actor Actor) error {
 
 	query := `UPDATE herbs
-		SET name = $1, description = $2, price = $3, culinary_uses = $4, version = version + 1
-		WHERE id = $5 AND version = $6
-		RETURNING version`
+		SET name = $1, description = $2, price_amount = $3, price_currency = $4, culinary_uses = $5, stock_unit = $6, version = version + 1
+		WHERE id = $7 AND version = $8
+		RETURNING stock_quantity, version`
 
 	args := []interface{}{
 		herb.Name,
 		herb.Description,
-		herb.Price,
+		herb.Price.Amount,
+		herb.Price.Currency,
 		pq.Array(herb.CulinaryUses),
+		herb.StockUnit,
 		herb.ID,
 		herb.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	current, err := getHerb(ctx, tx, herb.ID, true)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
 
-	err := h.DB.QueryRowContext(ctx, query, args...).Scan(&herb.Version)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&herb.StockQuantity, &herb.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -185,34 +668,257 @@ func (h HerbModel) Update(herb *Herb) error {
 		}
 	}
 
-	return nil
+	if current.Price != herb.Price {
+		change := &PriceChange{
+			HerbID:    herb.ID,
+			OldPrice:  current.Price,
+			NewPrice:  herb.Price,
+			ChangedBy: actor.UserID,
+		}
+
+		err = insertPriceChange(ctx, tx, change)
+		if err != nil {
+			return err
+		}
+	}
+
+	return insertAuditEvent(ctx, tx, actor, "update", "herb", herb.ID, current, herb)
 }
 
-func (h HerbModel) Delete(id int64) error {
+// Delete moves the herb to the trash, recording it in the audit trail against actor. It
+// is hidden from everything but GetAllDeleted until it is restored, or purged for good
+// by Purge. If version isn't 0, the herb is only deleted if it is still at that
+// version, and ErrEditConflict is returned if it isn't.
+func (h HerbModel) Delete(id int64, version int32, actor Actor) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = deleteHerb(ctx, tx, id, version, actor)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deleteHerb is Delete as one step of the transaction tx.
+func deleteHerb(ctx context.Context, tx *sql.Tx, id int64, version int32, actor Actor) error {
+
+	query := `UPDATE herbs
+		SET deleted_at = NOW(), version = version + 1
+		WHERE id = $1
+		RETURNING deleted_at, version`
+
+	current, err := getHerb(ctx, tx, id, true)
+	if err != nil {
+		return err
+	}
+
+	if version != 0 && current.Version != version {
+		return ErrEditConflict
+	}
+
+	deleted := *current
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(&deleted.DeletedAt, &deleted.Version)
+	if err != nil {
+		return err
+	}
+
+	return insertAuditEvent(ctx, tx, actor, "delete", "herb", id, current, &deleted)
+}
+
+// Restore takes the herb out of the trash and returns it, recording it in the audit
+// trail against actor. It returns ErrRecordNotFound if there is no such herb in the
+// trash.
+func (h HerbModel) Restore(id int64, actor Actor) (*Herb, error) {
 
 	if id < 1 {
-		return ErrRecordNotFound
+		return nil, ErrRecordNotFound
 	}
 
-	query := `DELETE FROM herbs
-		WHERE id = $1`
+	query := `UPDATE herbs
+		SET deleted_at = NULL, version = version + 1
+		WHERE id = $1
+		RETURNING id, created_at, name, description, price_amount, price_currency, culinary_uses, stock_quantity, stock_unit, average_rating, review_count, version`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := h.DB.ExecContext(ctx, query, id)
+	tx, err := h.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := result.RowsAffected()
+	var deletedAt time.Time
+
+	err = tx.QueryRowContext(ctx, `SELECT deleted_at FROM herbs WHERE id = $1 AND deleted_at IS NOT NULL FOR UPDATE`, id).Scan(&deletedAt)
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	var herb Herb
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(
+		&herb.ID,
+		&herb.CreatedAt,
+		&herb.Name,
+		&herb.Description,
+		&herb.Price.Amount,
+		&herb.Price.Currency,
+		pq.Array(&herb.CulinaryUses),
+		&herb.StockQuantity,
+		&herb.StockUnit,
+		&herb.AverageRating,
+		&herb.ReviewCount,
+		&herb.Version,
+	)
+	if err != nil {
+		return nil, err
 	}
 
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	deleted := herb
+	deleted.DeletedAt = &deletedAt
+	deleted.Version--
+
+	err = insertAuditEvent(ctx, tx, actor, "restore", "herb", id, &deleted, &herb)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &herb, nil
+}
+
+// GetAllDeleted returns a page of the herbs in the trash.
+func (h HerbModel) GetAllDeleted(filters Filters) ([]*Herb, Metadata, error) {
+
+	query := fmt.Sprintf(`SELECT count(*) OVER(), id, created_at, name, description, price_amount, price_currency, culinary_uses, stock_quantity, stock_unit, average_rating, review_count, version, deleted_at
+		FROM herbs
+		WHERE deleted_at IS NOT NULL
+		ORDER BY %s %s, id ASC
+		LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := h.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	herbs := []*Herb{}
+
+	for rows.Next() {
+
+		var herb Herb
+
+		err := rows.Scan(
+			&totalRecords,
+			&herb.ID,
+			&herb.CreatedAt,
+			&herb.Name,
+			&herb.Description,
+			&herb.Price.Amount,
+			&herb.Price.Currency,
+			pq.Array(&herb.CulinaryUses),
+			&herb.StockQuantity,
+			&herb.StockUnit,
+			&herb.AverageRating,
+			&herb.ReviewCount,
+			&herb.Version,
+			&herb.DeletedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		herbs = append(herbs, &herb)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return herbs, metadata, nil
+}
+
+// Purge permanently deletes the herbs which were moved to the trash before the given
+// time, along with their variants, images, reviews and everything else that belongs to
+// them. Each purge is recorded in the audit trail in the same statement. It returns
+// how many herbs were purged and the storage keys of their images, which are left for
+// the caller to remove.
+func (h HerbModel) Purge(before time.Time) (int, []string, error) {
+	// The SELECT sees the images as they were before the DELETE, so it still finds the
+	// ones that are about to go with their herbs.
+	query := `WITH purged AS (
+			DELETE FROM herbs
+			WHERE deleted_at < $1
+			RETURNING id
+		), audited AS (
+			INSERT INTO audit_events (action, entity_type, entity_id)
+			SELECT 'purge', 'herb', id FROM purged
+		)
+		SELECT purged.id, coalesce(herb_images.image_key, ''), coalesce(herb_images.thumbnail_key, '')
+		FROM purged
+		LEFT JOIN herb_images ON herb_images.herb_id = purged.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := h.DB.QueryContext(ctx, query, before)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	purged := make(map[int64]bool)
+	keys := []string{}
+
+	for rows.Next() {
+		var (
+			id                     int64
+			imageKey, thumbnailKey string
+		)
+
+		err := rows.Scan(&id, &imageKey, &thumbnailKey)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		purged[id] = true
+
+		for _, key := range []string{imageKey, thumbnailKey} {
+			if key != "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	return len(purged), keys, nil
 }
@@ -0,0 +1,157 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	ErrIdempotencyKeyMismatch   = errors.New("idempotency key used for a different request")
+	ErrIdempotencyKeyInProgress = errors.New("idempotency key in progress")
+)
+
+// idempotencyLockTimeout is how long a request holds its idempotency key before a
+// retry may take it over, in case the request never finished, e.g. because the server
+// stopped while handling it.
+const idempotencyLockTimeout = 5 * time.Minute
+
+// IdempotentResponse is the response stored for a request made with an idempotency key,
+// which is sent again when the request is retried.
+type IdempotentResponse struct {
+	StatusCode int
+	Header     map[string]string
+	Body       []byte
+}
+
+type IdempotencyKeyModel struct {
+	DB *sql.DB
+}
+
+// Begin claims the idempotency key for a request whose hash is given. Keys belong to an
+// owner, which identifies the client that sent them, so that clients can't collide
+// with each other's keys. If the key is new, or its last use has expired, it returns
+// nil and the caller should handle the request and then either Complete or Release the
+// key. If the key has been used for the same request it returns the stored response,
+// or ErrIdempotencyKeyInProgress if that request hasn't finished yet, and
+// ErrIdempotencyKeyMismatch if it was used for a different request.
+func (m IdempotencyKeyModel) Begin(owner, key string, hash []byte, ttl time.Duration) (*IdempotentResponse, error) {
+	query := `INSERT INTO idempotency_keys (owner, key, request_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (owner, key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, status_code = NULL, response_headers = NULL,
+			response_body = NULL, created_at = NOW(), expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= NOW()
+		OR (idempotency_keys.status_code IS NULL AND idempotency_keys.created_at < $5)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{owner, key, hash, time.Now().Add(ttl), time.Now().Add(-idempotencyLockTimeout)}
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 1 {
+		return nil, nil
+	}
+
+	query = `SELECT request_hash, status_code, response_headers, response_body
+		FROM idempotency_keys
+		WHERE owner = $1 AND key = $2`
+
+	var (
+		storedHash []byte
+		statusCode sql.NullInt32
+		header     []byte
+		response   IdempotentResponse
+	)
+
+	err = m.DB.QueryRowContext(ctx, query, owner, key).Scan(&storedHash, &statusCode, &header, &response.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if !bytes.Equal(storedHash, hash) {
+		return nil, ErrIdempotencyKeyMismatch
+	}
+
+	if !statusCode.Valid {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+
+	response.StatusCode = int(statusCode.Int32)
+
+	err = json.Unmarshal(header, &response.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// Complete stores the response to the request holding the idempotency key, for retries
+// to replay.
+func (m IdempotencyKeyModel) Complete(owner, key string, response *IdempotentResponse) error {
+	header, err := json.Marshal(response.Header)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE idempotency_keys
+		SET status_code = $1, response_headers = $2, response_body = $3
+		WHERE owner = $4 AND key = $5`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{response.StatusCode, header, response.Body, owner, key}
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Release gives up an idempotency key claimed by Begin without storing a response, so
+// that the request can be retried.
+func (m IdempotencyKeyModel) Release(owner, key string) error {
+	query := `DELETE FROM idempotency_keys
+		WHERE owner = $1 AND key = $2 AND status_code IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, owner, key)
+	return err
+}
+
+// DeleteExpired deletes the idempotency keys which are past their TTL, and returns how
+// many there were.
+func (m IdempotencyKeyModel) DeleteExpired() (int64, error) {
+	query := `DELETE FROM idempotency_keys
+		WHERE expires_at <= NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,175 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+// ImageContentTypes are the image formats which can be uploaded. They are the formats
+// the standard library can decode, so that every image gets a thumbnail.
+var ImageContentTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// HerbImage is a picture of a herb. The files themselves live in storage under
+// ImageKey and ThumbnailKey; the URLs are filled in from those by the handlers.
+type HerbImage struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"-"`
+	HerbID       int64     `json:"herb_id"`
+	Position     int32     `json:"position"` // Images are shown in ascending position
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"` // Empty until the thumbnail has been generated
+	ContentType  string    `json:"content_type"`
+	SizeBytes    int64     `json:"size_bytes"`
+	ImageKey     string    `json:"-"`
+	ThumbnailKey string    `json:"-"`
+}
+
+func ValidateHerbImage(v *validator.Validator, image *HerbImage) {
+	v.Check(image.SizeBytes > 0, "image", "must not be empty")
+	v.Check(validator.In(image.ContentType, ImageContentTypes...), "image", "must be a JPEG, PNG or GIF image")
+}
+
+type HerbImageModel struct {
+	DB *sql.DB
+}
+
+// Insert adds the image after the herb's existing images.
+func (m HerbImageModel) Insert(image *HerbImage) error {
+	query := `INSERT INTO herb_images (herb_id, position, image_key, content_type, size_bytes)
+		VALUES ($1, (SELECT coalesce(max(position), 0) + 1 FROM herb_images WHERE herb_id = $1), $2, $3, $4)
+		RETURNING id, created_at, position`
+
+	args := []interface{}{image.HerbID, image.ImageKey, image.ContentType, image.SizeBytes}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&image.ID, &image.CreatedAt, &image.Position)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "herb_images" violates foreign key constraint "herb_images_herb_id_fkey"`:
+			return ErrUnknownHerb
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAllForHerbs returns the images of each of the given herbs in display order, keyed
+// by herb ID.
+func (m HerbImageModel) GetAllForHerbs(herbIDs []int64) (map[int64][]*HerbImage, error) {
+	query := `SELECT id, created_at, herb_id, position, image_key, coalesce(thumbnail_key, ''), content_type, size_bytes
+		FROM herb_images
+		WHERE herb_id = ANY($1)
+		ORDER BY herb_id, position, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(herbIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := make(map[int64][]*HerbImage)
+
+	for rows.Next() {
+		var image HerbImage
+
+		err := rows.Scan(
+			&image.ID,
+			&image.CreatedAt,
+			&image.HerbID,
+			&image.Position,
+			&image.ImageKey,
+			&image.ThumbnailKey,
+			&image.ContentType,
+			&image.SizeBytes,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		images[image.HerbID] = append(images[image.HerbID], &image)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// SetThumbnail records the storage key of the image's thumbnail once it has been
+// generated. It returns ErrRecordNotFound if the image was deleted in the meantime.
+func (m HerbImageModel) SetThumbnail(id int64, key string) error {
+	query := `UPDATE herb_images
+		SET thumbnail_key = $1
+		WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, key, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Delete removes the image from the herb, returning it so that the caller can remove
+// its files from storage.
+func (m HerbImageModel) Delete(herbID, id int64) (*HerbImage, error) {
+	if herbID < 1 || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `DELETE FROM herb_images
+		WHERE herb_id = $1 AND id = $2
+		RETURNING id, created_at, herb_id, position, image_key, coalesce(thumbnail_key, ''), content_type, size_bytes`
+
+	var image HerbImage
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, herbID, id).Scan(
+		&image.ID,
+		&image.CreatedAt,
+		&image.HerbID,
+		&image.Position,
+		&image.ImageKey,
+		&image.ThumbnailKey,
+		&image.ContentType,
+		&image.SizeBytes,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &image, nil
+}
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 )
@@ -11,17 +12,57 @@ var (
 )
 
 type Models struct {
-	Herbs       HerbModel
-	Permissions PermissionModel
-	Tokens      TokenModel
-	Users       UserModel
+	AuditEvents     AuditEventModel
+	Carts           CartModel
+	Categories      CategoryModel
+	Currencies      CurrencyModel
+	ExchangeRates   ExchangeRateModel
+	Herbs           HerbModel
+	HerbImages      HerbImageModel
+	HerbVariants    HerbVariantModel
+	IdempotencyKeys IdempotencyKeyModel
+	Orders          OrderModel
+	Permissions     PermissionModel
+	PriceHistory    PriceHistoryModel
+	Promotions      PromotionModel
+	PurchaseOrders  PurchaseOrderModel
+	Reviews         ReviewModel
+	ScheduledPrices ScheduledPriceModel
+	StockMovements  StockMovementModel
+	Suppliers       SupplierModel
+	Tokens          TokenModel
+	Users           UserModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Herbs:       HerbModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Users:       UserModel{DB: db},
+		AuditEvents:     AuditEventModel{DB: db},
+		Carts:           CartModel{DB: db},
+		Categories:      CategoryModel{DB: db},
+		Currencies:      CurrencyModel{DB: db},
+		ExchangeRates:   ExchangeRateModel{DB: db},
+		Herbs:           HerbModel{DB: db},
+		HerbImages:      HerbImageModel{DB: db},
+		HerbVariants:    HerbVariantModel{DB: db},
+		IdempotencyKeys: IdempotencyKeyModel{DB: db},
+		Orders:          OrderModel{DB: db},
+		Permissions:     PermissionModel{DB: db},
+		PriceHistory:    PriceHistoryModel{DB: db},
+		Promotions:      PromotionModel{DB: db},
+		PurchaseOrders:  PurchaseOrderModel{DB: db},
+		Reviews:         ReviewModel{DB: db},
+		ScheduledPrices: ScheduledPriceModel{DB: db},
+		StockMovements:  StockMovementModel{DB: db},
+		Suppliers:       SupplierModel{DB: db},
+		Tokens:          TokenModel{DB: db},
+		Users:           UserModel{DB: db},
 	}
 }
+
+// dbtx is implemented by both *sql.DB and *sql.Tx. Helpers which accept one can be used
+// on their own or as one step of a transaction spanning several models.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
@@ -0,0 +1,495 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+var (
+	ErrEmptyCart    = errors.New("empty cart")
+	ErrPriceChanged = errors.New("price changed")
+)
+
+const (
+	OrderPending   = "pending"
+	OrderPaid      = "paid"
+	OrderShipped   = "shipped"
+	OrderCancelled = "cancelled"
+)
+
+var OrderStatuses = []string{OrderPending, OrderPaid, OrderShipped, OrderCancelled}
+
+// orderTransitions lists the statuses an order may move to from each status. Shipped
+// and cancelled orders are final.
+var orderTransitions = map[string][]string{
+	OrderPending: {OrderPaid, OrderCancelled},
+	OrderPaid:    {OrderShipped, OrderCancelled},
+}
+
+// Order is a customer's purchase. All of its prices are in the order's currency, fixed
+// at the exchange rates in force when it was placed.
+type Order struct {
+	ID          int64        `json:"id"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UserID      int64        `json:"user_id"`
+	Status      string       `json:"status"`
+	Subtotal    Price        `json:"subtotal"`
+	Discount    Price        `json:"discount"`
+	Total       Price        `json:"total"`
+	CouponCode  string       `json:"coupon_code,omitempty"`
+	PaidAt      *time.Time   `json:"paid_at,omitempty"`
+	ShippedAt   *time.Time   `json:"shipped_at,omitempty"`
+	CancelledAt *time.Time   `json:"cancelled_at,omitempty"`
+	Lines       []*OrderLine `json:"lines,omitempty"`
+	Version     int32        `json:"version"`
+}
+
+// OrderLine is a quantity of one herb on an order. As with purchase order lines, the
+// herb name is copied so that it survives the herb being deleted.
+type OrderLine struct {
+	ID        int64  `json:"id"`
+	HerbID    int64  `json:"herb_id,omitempty"`
+	HerbName  string `json:"herb_name"`
+	Quantity  int64  `json:"quantity"`
+	UnitPrice Price  `json:"unit_price"`
+	LineTotal Price  `json:"line_total"`
+}
+
+// CanTransition returns true if the order may move to the given status.
+func (o *Order) CanTransition(status string) bool {
+	return validator.In(status, orderTransitions[o.Status]...)
+}
+
+type OrderModel struct {
+	DB *sql.DB
+}
+
+// Place turns the user's cart into an order priced in the given currency. In a single
+// transaction it locks the cart and every herb in it, checks that no price has changed
+// since the item was added, applies any running sales and the coupon if one is given,
+// takes the ordered quantities out of stock and empties the cart. ErrPriceChanged, which
+// is also returned if a herb was trashed meanwhile, ErrInsufficientStock and the coupon
+// errors leave everything untouched. The stock changes are audited against actor.
+func (m OrderModel) Place(userID int64, currency string, couponCode string, actor Actor) (*Order, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	items, err := getCartItems(ctx, tx, userID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items) == 0 {
+		return nil, ErrEmptyCart
+	}
+
+	converter, err := loadConverter(ctx, tx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	// The cart items are ordered by herb ID, so concurrent checkouts lock the herbs in
+	// the same order and can't deadlock.
+	herbs := make([]*Herb, len(items))
+
+	for i, item := range items {
+		herbs[i], err = getHerb(ctx, tx, item.HerbID, true)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrRecordNotFound):
+				// The herb was moved to the trash since the cart was read. The cart
+				// leaves trashed herbs out, so the client can refresh it and try again.
+				return nil, ErrPriceChanged
+			default:
+				return nil, err
+			}
+		}
+
+		if herbs[i].Price != item.UnitPrice {
+			return nil, ErrPriceChanged
+		}
+	}
+
+	err = applySales(ctx, tx, herbs)
+	if err != nil {
+		return nil, err
+	}
+
+	lines, subtotal, err := priceOrderLines(items, herbs, converter)
+	if err != nil {
+		return nil, err
+	}
+
+	order := &Order{
+		UserID:   userID,
+		Subtotal: subtotal,
+		Discount: Price{Currency: currency},
+		Lines:    lines,
+	}
+
+	var promotion *Promotion
+
+	if couponCode != "" {
+		promotion, err = getCoupon(ctx, tx, couponCode, true)
+		if err != nil {
+			return nil, err
+		}
+
+		order.Discount, err = couponDiscount(ctx, tx, promotion, userID, lines, herbs, subtotal, converter)
+		if err != nil {
+			return nil, err
+		}
+
+		order.CouponCode = promotion.Code
+	}
+
+	order.Total, err = subtotal.Sub(order.Discount)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO orders (user_id, currency, subtotal_amount, discount_amount, total_amount, promotion_id, coupon_code)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))
+		RETURNING id, created_at, status, version`
+
+	var promotionID sql.NullInt64
+	if promotion != nil {
+		promotionID = sql.NullInt64{Int64: promotion.ID, Valid: true}
+	}
+
+	args := []interface{}{userID, currency, order.Subtotal.Amount, order.Discount.Amount, order.Total.Amount, promotionID, order.CouponCode}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&order.ID, &order.CreatedAt, &order.Status, &order.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	if promotion != nil {
+		query = `INSERT INTO promotion_redemptions (promotion_id, user_id, order_id, discount_amount, currency)
+			VALUES ($1, $2, $3, $4, $5)`
+
+		_, err = tx.ExecContext(ctx, query, promotion.ID, userID, order.ID, order.Discount.Amount, currency)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	query = `INSERT INTO order_lines (order_id, herb_id, herb_name, quantity, unit_price_amount, line_total_amount)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id`
+
+	for _, line := range order.Lines {
+		args := []interface{}{order.ID, line.HerbID, line.HerbName, line.Quantity, line.UnitPrice.Amount, line.LineTotal.Amount}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&line.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		movement := &StockMovement{
+			HerbID:   line.HerbID,
+			Kind:     StockMovementSell,
+			Quantity: -line.Quantity,
+			Note:     fmt.Sprintf("order #%d", order.ID),
+			UserID:   userID,
+		}
+
+		err = insertStockMovement(ctx, tx, movement, nil, actor)
+		if err != nil {
+			if errors.Is(err, ErrInsufficientStock) {
+				return nil, fmt.Errorf("%w: %s", err, line.HerbName)
+			}
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM cart_items WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func (m OrderModel) Get(id int64) (*Order, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, created_at, user_id, status, currency, subtotal_amount, discount_amount, total_amount, coalesce(coupon_code, ''), paid_at, shipped_at, cancelled_at, version
+		FROM orders
+		WHERE id = $1`
+
+	var order Order
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&order.ID,
+		&order.CreatedAt,
+		&order.UserID,
+		&order.Status,
+		&order.Total.Currency,
+		&order.Subtotal.Amount,
+		&order.Discount.Amount,
+		&order.Total.Amount,
+		&order.CouponCode,
+		&order.PaidAt,
+		&order.ShippedAt,
+		&order.CancelledAt,
+		&order.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	order.Subtotal.Currency = order.Total.Currency
+	order.Discount.Currency = order.Total.Currency
+
+	query = `SELECT id, coalesce(herb_id, 0), herb_name, quantity, unit_price_amount, line_total_amount
+		FROM order_lines
+		WHERE order_id = $1
+		ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	order.Lines = []*OrderLine{}
+
+	for rows.Next() {
+		line := OrderLine{
+			UnitPrice: Price{Currency: order.Total.Currency},
+			LineTotal: Price{Currency: order.Total.Currency},
+		}
+
+		err := rows.Scan(
+			&line.ID,
+			&line.HerbID,
+			&line.HerbName,
+			&line.Quantity,
+			&line.UnitPrice.Amount,
+			&line.LineTotal.Amount,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		order.Lines = append(order.Lines, &line)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+// GetAll returns a page of orders without their lines. A non-zero userID limits the
+// results to that user's orders.
+func (m OrderModel) GetAll(userID int64, status string, filters Filters) ([]*Order, Metadata, error) {
+	query := fmt.Sprintf(`SELECT count(*) OVER(), id, created_at, user_id, status, currency, subtotal_amount, discount_amount, total_amount, coalesce(coupon_code, ''), paid_at, shipped_at, cancelled_at, version
+		FROM orders
+		WHERE (user_id = $1 OR $1 = 0)
+		AND (status = $2 OR $2 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{userID, status, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	orders := []*Order{}
+
+	for rows.Next() {
+		var order Order
+
+		err := rows.Scan(
+			&totalRecords,
+			&order.ID,
+			&order.CreatedAt,
+			&order.UserID,
+			&order.Status,
+			&order.Total.Currency,
+			&order.Subtotal.Amount,
+			&order.Discount.Amount,
+			&order.Total.Amount,
+			&order.CouponCode,
+			&order.PaidAt,
+			&order.ShippedAt,
+			&order.CancelledAt,
+			&order.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		order.Subtotal.Currency = order.Total.Currency
+		order.Discount.Currency = order.Total.Currency
+
+		orders = append(orders, &order)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return orders, metadata, nil
+}
+
+// Transition moves an order to a new status. Cancelling an order puts its lines back
+// into stock with adjust movements in the same transaction, and frees up its use of
+// any coupon. The stock changes are audited against actor.
+func (m OrderModel) Transition(order *Order, status string, actor Actor) error {
+	if !order.CanTransition(status) {
+		return ErrInvalidStatusTransition
+	}
+
+	query := `UPDATE orders
+		SET status = $1,
+			paid_at = CASE WHEN $1 = 'paid' THEN NOW() ELSE paid_at END,
+			shipped_at = CASE WHEN $1 = 'shipped' THEN NOW() ELSE shipped_at END,
+			cancelled_at = CASE WHEN $1 = 'cancelled' THEN NOW() ELSE cancelled_at END,
+			version = version + 1
+		WHERE id = $2 AND version = $3 AND status = $4
+		RETURNING paid_at, shipped_at, cancelled_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	args := []interface{}{status, order.ID, order.Version, order.Status}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&order.PaidAt, &order.ShippedAt, &order.CancelledAt, &order.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if status == OrderCancelled {
+		_, err = tx.ExecContext(ctx, `DELETE FROM promotion_redemptions WHERE order_id = $1`, order.ID)
+		if err != nil {
+			return err
+		}
+
+		for _, line := range order.Lines {
+			if line.HerbID == 0 {
+				continue
+			}
+
+			_, err := getHerb(ctx, tx, line.HerbID, true)
+			if err != nil {
+				switch {
+				case errors.Is(err, ErrRecordNotFound):
+					continue
+				default:
+					return err
+				}
+			}
+
+			movement := &StockMovement{
+				HerbID:   line.HerbID,
+				Kind:     StockMovementAdjust,
+				Quantity: line.Quantity,
+				Note:     fmt.Sprintf("order #%d cancelled", order.ID),
+				UserID:   actor.UserID,
+			}
+
+			err = insertStockMovement(ctx, tx, movement, nil, actor)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	order.Status = status
+	return nil
+}
+
+// priceOrderLines prices each cart item in the converter's currency, using its herb's
+// sale price where it has one, and returns the lines with their subtotal. herbs must
+// be in the same order as items.
+func priceOrderLines(items []*CartItem, herbs []*Herb, converter *Converter) ([]*OrderLine, Price, error) {
+	lines := make([]*OrderLine, len(items))
+	subtotal := Price{Currency: converter.Currency}
+
+	for i, item := range items {
+		price := herbs[i].Price
+		if herbs[i].SalePrice != nil {
+			price = *herbs[i].SalePrice
+		}
+
+		unitPrice, err := converter.Convert(price)
+		if err != nil {
+			return nil, Price{}, err
+		}
+
+		lineTotal, err := unitPrice.Mul(item.Quantity)
+		if err != nil {
+			return nil, Price{}, err
+		}
+
+		subtotal, err = subtotal.Add(lineTotal)
+		if err != nil {
+			return nil, Price{}, err
+		}
+
+		lines[i] = &OrderLine{
+			HerbID:    herbs[i].ID,
+			HerbName:  herbs[i].Name,
+			Quantity:  item.Quantity,
+			UnitPrice: unitPrice,
+			LineTotal: lineTotal,
+		}
+	}
+
+	return lines, subtotal, nil
+}
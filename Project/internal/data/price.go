@@ -3,37 +3,208 @@ package data
 import (
 	"errors"
 	"fmt"
+	"math"
+	"math/big"
 	"strconv"
 	"strings"
+	"sync"
+
+	"gourmetspices.yerassyl.net/internal/validator"
 )
 
-var ErrInvalidPriceFormat = errors.New("invalid price format")
+var (
+	ErrInvalidPriceFormat  = errors.New("invalid price format")
+	ErrUnsupportedCurrency = errors.New("unsupported currency")
+	ErrCurrencyMismatch    = errors.New("currency mismatch")
+	ErrPriceOverflow       = errors.New("price overflow")
+)
 
-type Price float32
+// DefaultCurrency is the currency totals are given in when the client doesn't ask for
+// another one.
+const DefaultCurrency = "USD"
 
-func (p Price) MarshalJSON() ([]byte, error) {
-	jsonValue := fmt.Sprintf("%.2f USD", p)
-	quotedJSONValue := strconv.Quote(jsonValue)
+// currencyExponents lists the ISO 4217 currencies we accept, along with the number of
+// digits after the decimal separator in each currency's minor unit. It is filled from
+// the currencies table by CurrencyModel.Load.
+var currencyExponents = struct {
+	sync.RWMutex
+	m map[string]int
+}{m: make(map[string]int)}
 
-	return []byte(quotedJSONValue), nil
+// currencyExponent returns the number of digits in the minor unit of the currency, and
+// false if it isn't one we accept.
+func currencyExponent(code string) (int, bool) {
+	currencyExponents.RLock()
+	defer currencyExponents.RUnlock()
+
+	exponent, ok := currencyExponents.m[code]
+	return exponent, ok
 }
 
-func (p *Price) UnmarshalJSON(jsonValue []byte) error {
-	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
+// SupportedCurrency returns true if the given ISO 4217 code is one we can price in.
+func SupportedCurrency(code string) bool {
+	_, ok := currencyExponent(code)
+	return ok
+}
+
+// Price is an exact monetary amount. Amount holds an integer number of minor units
+// (e.g. cents for USD) so that no precision is lost to floating point arithmetic.
+type Price struct {
+	Amount   int64
+	Currency string
+}
+
+// ParsePrice parses a price in the "<decimal amount> <currency>" format used in our JSON
+// representation, for example "12.50 USD". The amount may not have more fractional
+// digits than the currency's minor unit allows.
+func ParsePrice(s string) (Price, error) {
+	parts := strings.Split(s, " ")
+	if len(parts) != 2 {
+		return Price{}, ErrInvalidPriceFormat
+	}
+
+	amount, currency := parts[0], parts[1]
+
+	exponent, ok := currencyExponent(currency)
+	if !ok {
+		return Price{}, ErrUnsupportedCurrency
+	}
+
+	negative := strings.HasPrefix(amount, "-")
+	amount = strings.TrimPrefix(amount, "-")
+
+	whole, fraction, _ := strings.Cut(amount, ".")
+	if whole == "" || len(fraction) > exponent || strings.HasSuffix(amount, ".") {
+		return Price{}, ErrInvalidPriceFormat
+	}
+
+	digits := whole + fraction + strings.Repeat("0", exponent-len(fraction))
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return Price{}, ErrInvalidPriceFormat
+		}
+	}
+
+	minor, err := strconv.ParseInt(digits, 10, 64)
 	if err != nil {
-		return ErrInvalidPriceFormat
+		return Price{}, ErrInvalidPriceFormat
+	}
+	if negative {
+		minor = -minor
 	}
 
-	parts := strings.Split(unquotedJSONValue, " ")
+	return Price{Amount: minor, Currency: currency}, nil
+}
 
-	if len(parts) != 2 {
-		return ErrInvalidPriceFormat
+// String formats the price as "<decimal amount> <currency>", for example "12.50 USD".
+func (p Price) String() string {
+	exponent, _ := currencyExponent(p.Currency)
+
+	sign := ""
+	amount := p.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	if exponent == 0 {
+		return fmt.Sprintf("%s%d %s", sign, amount, p.Currency)
+	}
+
+	scale := int64(math.Pow10(exponent))
+	return fmt.Sprintf("%s%d.%0*d %s", sign, amount/scale, exponent, amount%scale, p.Currency)
+}
+
+// Convert returns the price expressed in another currency, given the number of units
+// of that currency one unit of p.Currency buys. The result is rounded half away from
+// zero to the target currency's minor unit.
+func (p Price) Convert(currency string, rate *big.Rat) (Price, error) {
+	exponent, ok := currencyExponent(currency)
+	if !ok {
+		return Price{}, ErrUnsupportedCurrency
+	}
+
+	fromExponent, _ := currencyExponent(p.Currency)
+
+	value := new(big.Rat).SetInt64(p.Amount)
+	value.Mul(value, rate)
+	value.Mul(value, new(big.Rat).SetFrac(
+		new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil),
+		new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(fromExponent)), nil),
+	))
+
+	quotient, remainder := new(big.Int).QuoRem(value.Num(), value.Denom(), new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2)).Cmp(value.Denom()) >= 0 {
+		quotient.Add(quotient, big.NewInt(int64(value.Sign())))
+	}
+
+	if !quotient.IsInt64() {
+		return Price{}, ErrInvalidPriceFormat
+	}
+
+	return Price{Amount: quotient.Int64(), Currency: currency}, nil
+}
+
+// Add returns the sum of two prices in the same currency.
+func (p Price) Add(q Price) (Price, error) {
+	if p.Currency != q.Currency {
+		return Price{}, ErrCurrencyMismatch
+	}
+
+	sum := p.Amount + q.Amount
+	if (q.Amount > 0 && sum < p.Amount) || (q.Amount < 0 && sum > p.Amount) {
+		return Price{}, ErrPriceOverflow
+	}
+
+	return Price{Amount: sum, Currency: p.Currency}, nil
+}
+
+// Sub returns p less q, where both are in the same currency.
+func (p Price) Sub(q Price) (Price, error) {
+	return p.Add(Price{Amount: -q.Amount, Currency: q.Currency})
+}
+
+// Percent returns the given percentage of the price, rounded down to the minor unit.
+func (p Price) Percent(percent int64) Price {
+	amount := p.Amount/100*percent + p.Amount%100*percent/100
+	return Price{Amount: amount, Currency: p.Currency}
+}
+
+// Mul returns the price multiplied by a quantity, such as the total of an order line.
+func (p Price) Mul(quantity int64) (Price, error) {
+	if p.Amount == 0 || quantity == 0 {
+		return Price{Currency: p.Currency}, nil
 	}
 
-	i, err := strconv.ParseFloat(parts[0], 32)
+	product := p.Amount * quantity
+	if product/quantity != p.Amount {
+		return Price{}, ErrPriceOverflow
+	}
+
+	return Price{Amount: product, Currency: p.Currency}, nil
+}
+
+func (p Price) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(p.String())), nil
+}
+
+func (p *Price) UnmarshalJSON(jsonValue []byte) error {
+	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
 	if err != nil {
 		return ErrInvalidPriceFormat
 	}
-	*p = Price(i)
+
+	price, err := ParsePrice(unquotedJSONValue)
+	if err != nil {
+		return err
+	}
+
+	*p = price
 	return nil
 }
+
+func ValidatePrice(v *validator.Validator, key string, p Price) {
+	v.Check(p.Amount != 0, key, "must be provided")
+	v.Check(p.Amount >= 0, key, "must be a positive amount")
+	v.Check(SupportedCurrency(p.Currency), key, "must use a supported currency")
+}
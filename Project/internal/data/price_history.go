@@ -0,0 +1,322 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+// PriceChange records a change to a herb's price. ChangedBy is the user who made the
+// change, or who scheduled it if it was applied by the price scheduler.
+type PriceChange struct {
+	ID               int64     `json:"id"`
+	HerbID           int64     `json:"herb_id"`
+	ChangedAt        time.Time `json:"changed_at"`
+	OldPrice         Price     `json:"old_price"`
+	NewPrice         Price     `json:"new_price"`
+	ChangedBy        int64     `json:"changed_by,omitempty"`
+	ScheduledPriceID int64     `json:"scheduled_price_id,omitempty"` // Set when the change came from a scheduled price
+}
+
+// ScheduledPrice is a price which will be applied to a herb from EffectiveAt onwards.
+type ScheduledPrice struct {
+	ID          int64      `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	HerbID      int64      `json:"herb_id"`
+	Price       Price      `json:"price"`
+	EffectiveAt time.Time  `json:"effective_at"`
+	CreatedBy   int64      `json:"created_by,omitempty"`
+	AppliedAt   *time.Time `json:"applied_at,omitempty"`
+}
+
+func ValidateScheduledPrice(v *validator.Validator, sp *ScheduledPrice) {
+	ValidatePrice(v, "price", sp.Price)
+
+	v.Check(!sp.EffectiveAt.IsZero(), "effective_at", "must be provided")
+	v.Check(sp.EffectiveAt.After(time.Now()), "effective_at", "must be in the future")
+}
+
+type PriceHistoryModel struct {
+	DB *sql.DB
+}
+
+func (m PriceHistoryModel) GetAllForHerb(herbID int64, filters Filters) ([]*PriceChange, Metadata, error) {
+	query := fmt.Sprintf(`SELECT count(*) OVER(), id, herb_id, changed_at, old_price_amount, old_price_currency,
+			new_price_amount, new_price_currency, coalesce(changed_by, 0), coalesce(scheduled_price_id, 0)
+		FROM herb_price_history
+		WHERE herb_id = $1
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, herbID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	changes := []*PriceChange{}
+
+	for rows.Next() {
+		var change PriceChange
+
+		err := rows.Scan(
+			&totalRecords,
+			&change.ID,
+			&change.HerbID,
+			&change.ChangedAt,
+			&change.OldPrice.Amount,
+			&change.OldPrice.Currency,
+			&change.NewPrice.Amount,
+			&change.NewPrice.Currency,
+			&change.ChangedBy,
+			&change.ScheduledPriceID,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		changes = append(changes, &change)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return changes, metadata, nil
+}
+
+// insertPriceChange records a price change made as part of the transaction tx.
+func insertPriceChange(ctx context.Context, tx *sql.Tx, change *PriceChange) error {
+	query := `INSERT INTO herb_price_history (herb_id, old_price_amount, old_price_currency, new_price_amount, new_price_currency, changed_by, scheduled_price_id)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), NULLIF($7, 0))
+		RETURNING id, changed_at`
+
+	args := []interface{}{
+		change.HerbID,
+		change.OldPrice.Amount,
+		change.OldPrice.Currency,
+		change.NewPrice.Amount,
+		change.NewPrice.Currency,
+		change.ChangedBy,
+		change.ScheduledPriceID,
+	}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&change.ID, &change.ChangedAt)
+}
+
+type ScheduledPriceModel struct {
+	DB *sql.DB
+}
+
+func (m ScheduledPriceModel) Insert(sp *ScheduledPrice) error {
+	query := `INSERT INTO herb_scheduled_prices (herb_id, price_amount, price_currency, effective_at, created_by)
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0))
+		RETURNING id, created_at`
+
+	args := []interface{}{sp.HerbID, sp.Price.Amount, sp.Price.Currency, sp.EffectiveAt, sp.CreatedBy}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&sp.ID, &sp.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "herb_scheduled_prices" violates foreign key constraint "herb_scheduled_prices_herb_id_fkey"`:
+			return ErrUnknownHerb
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAllForHerb returns the herb's scheduled prices in the order they take effect. Unless
+// all is true, prices which have already been applied are left out.
+func (m ScheduledPriceModel) GetAllForHerb(herbID int64, all bool) ([]*ScheduledPrice, error) {
+	query := `SELECT id, created_at, herb_id, price_amount, price_currency, effective_at, coalesce(created_by, 0), applied_at
+		FROM herb_scheduled_prices
+		WHERE herb_id = $1 AND (applied_at IS NULL OR $2)
+		ORDER BY effective_at, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, herbID, all)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prices := []*ScheduledPrice{}
+
+	for rows.Next() {
+		var sp ScheduledPrice
+
+		err := rows.Scan(
+			&sp.ID,
+			&sp.CreatedAt,
+			&sp.HerbID,
+			&sp.Price.Amount,
+			&sp.Price.Currency,
+			&sp.EffectiveAt,
+			&sp.CreatedBy,
+			&sp.AppliedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		prices = append(prices, &sp)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return prices, nil
+}
+
+// Delete cancels a scheduled price. Prices which have already been applied are part of
+// the herb's history and can't be deleted, so those return ErrRecordNotFound.
+func (m ScheduledPriceModel) Delete(herbID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM herb_scheduled_prices
+		WHERE id = $1 AND herb_id = $2 AND applied_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, herbID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// ApplyDue applies every scheduled price whose time has come, in a single transaction,
+// and returns how many were applied. Due prices are claimed with SKIP LOCKED so that
+// several API instances can run the scheduler at once without applying one twice.
+// Prices for herbs in the trash are left until the herb is restored. Each change is
+// recorded in the audit trail against the user who scheduled it.
+func (m ScheduledPriceModel) ApplyDue() (int, error) {
+	query := `SELECT id, herb_id, price_amount, price_currency, coalesce(created_by, 0)
+		FROM herb_scheduled_prices
+		WHERE applied_at IS NULL AND effective_at <= NOW()
+		ORDER BY herb_id, effective_at, id
+		FOR UPDATE SKIP LOCKED`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	due := []*ScheduledPrice{}
+
+	for rows.Next() {
+		var sp ScheduledPrice
+
+		err := rows.Scan(&sp.ID, &sp.HerbID, &sp.Price.Amount, &sp.Price.Currency, &sp.CreatedBy)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		due = append(due, &sp)
+	}
+
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	applied := 0
+
+	for _, sp := range due {
+		herb, err := getHerb(ctx, tx, sp.HerbID, true)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrRecordNotFound):
+				continue
+			default:
+				return 0, err
+			}
+		}
+
+		if herb.Price != sp.Price {
+			updated := *herb
+			updated.Price = sp.Price
+
+			err = tx.QueryRowContext(ctx, `UPDATE herbs SET price_amount = $1, price_currency = $2, version = version + 1 WHERE id = $3 RETURNING version`,
+				sp.Price.Amount, sp.Price.Currency, herb.ID).Scan(&updated.Version)
+			if err != nil {
+				return 0, err
+			}
+
+			// The change is made by the scheduler on behalf of whoever scheduled it.
+			err = insertAuditEvent(ctx, tx, Actor{UserID: sp.CreatedBy}, "update", "herb", herb.ID, herb, &updated)
+			if err != nil {
+				return 0, err
+			}
+
+			change := &PriceChange{
+				HerbID:           herb.ID,
+				OldPrice:         herb.Price,
+				NewPrice:         sp.Price,
+				ChangedBy:        sp.CreatedBy,
+				ScheduledPriceID: sp.ID,
+			}
+
+			err = insertPriceChange(ctx, tx, change)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE herb_scheduled_prices SET applied_at = NOW() WHERE id = $1`, sp.ID)
+		if err != nil {
+			return 0, err
+		}
+
+		applied++
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return applied, nil
+}
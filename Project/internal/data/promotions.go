@@ -0,0 +1,695 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+var (
+	ErrDuplicateCouponCode = errors.New("duplicate coupon code")
+	ErrInvalidCoupon       = errors.New("invalid coupon")
+	ErrCouponExpired       = errors.New("coupon expired")
+	ErrCouponLimitReached  = errors.New("coupon limit reached")
+	ErrCouponMinOrderValue = errors.New("order below coupon minimum")
+	ErrCouponNotApplicable = errors.New("coupon not applicable")
+)
+
+const (
+	PromotionPercent = "percent"
+	PromotionAmount  = "amount"
+)
+
+var PromotionKinds = []string{PromotionPercent, PromotionAmount}
+
+var CouponCodeRX = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+// Promotion is either a coupon, which customers redeem with its Code at checkout, or an
+// automatic sale when Code is empty, which lowers the price of the herbs it targets.
+// A promotion with no herbs, categories or culinary uses targets every herb.
+type Promotion struct {
+	ID             int64      `json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	Code           string     `json:"code,omitempty"`
+	Name           string     `json:"name"`
+	Kind           string     `json:"kind"`
+	PercentOff     int32      `json:"percent_off,omitempty"`
+	AmountOff      *Price     `json:"amount_off,omitempty"`
+	MinOrderValue  *Price     `json:"min_order_value,omitempty"` // Coupons only
+	StartsAt       time.Time  `json:"starts_at"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	HerbIDs        []int64    `json:"herb_ids"`
+	CategoryIDs    []int64    `json:"category_ids"` // Includes the categories' descendants
+	CulinaryUses   []string   `json:"culinary_uses"`
+	MaxUses        int32      `json:"max_uses"`          // Coupons only, 0 for no limit
+	MaxUsesPerUser int32      `json:"max_uses_per_user"` // Coupons only, 0 for no limit
+	Uses           int32      `json:"uses"`
+	Active         bool       `json:"active"`
+	Version        int32      `json:"version"`
+}
+
+// CouponQuote is what a coupon would take off the user's cart, without redeeming it.
+type CouponQuote struct {
+	Code     string `json:"code"`
+	Subtotal Price  `json:"subtotal"`
+	Discount Price  `json:"discount"`
+	Total    Price  `json:"total"`
+}
+
+func ValidatePromotion(v *validator.Validator, p *Promotion) {
+	if p.Code != "" {
+		v.Check(validator.Matches(p.Code, CouponCodeRX), "code", "must be 3-32 upper case letters, digits, dashes or underscores")
+	}
+
+	v.Check(p.Name != "", "name", "must be provided")
+	v.Check(len(p.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	v.Check(validator.In(p.Kind, PromotionKinds...), "kind", "must be one of percent, amount")
+
+	switch p.Kind {
+	case PromotionPercent:
+		v.Check(p.PercentOff >= 1 && p.PercentOff <= 100, "percent_off", "must be between 1 and 100")
+		v.Check(p.AmountOff == nil, "amount_off", "must not be provided for percent promotions")
+	case PromotionAmount:
+		v.Check(p.PercentOff == 0, "percent_off", "must not be provided for amount promotions")
+		v.Check(p.AmountOff != nil, "amount_off", "must be provided")
+		if p.AmountOff != nil {
+			ValidatePrice(v, "amount_off", *p.AmountOff)
+			v.Check(p.AmountOff.Amount > 0, "amount_off", "must be greater than zero")
+		}
+	}
+
+	if p.MinOrderValue != nil {
+		v.Check(p.Code != "", "min_order_value", "must not be provided for sales")
+		ValidatePrice(v, "min_order_value", *p.MinOrderValue)
+		v.Check(p.MinOrderValue.Amount > 0, "min_order_value", "must be greater than zero")
+	}
+
+	if p.ExpiresAt != nil {
+		v.Check(p.ExpiresAt.After(p.StartsAt), "expires_at", "must be after starts_at")
+	}
+
+	v.Check(uniqueIDs(p.HerbIDs), "herb_ids", "must not contain duplicate values")
+	v.Check(uniqueIDs(p.CategoryIDs), "category_ids", "must not contain duplicate values")
+	v.Check(validator.Unique(p.CulinaryUses), "culinary_uses", "must not contain duplicate values")
+
+	v.Check(p.MaxUses >= 0, "max_uses", "must not be negative")
+	v.Check(p.MaxUsesPerUser >= 0, "max_uses_per_user", "must not be negative")
+	if p.Code == "" {
+		v.Check(p.MaxUses == 0, "max_uses", "must not be provided for sales")
+		v.Check(p.MaxUsesPerUser == 0, "max_uses_per_user", "must not be provided for sales")
+	}
+}
+
+// appliesTo returns true if the promotion targets the herb. categoryIDs holds the
+// categories the herb is filed under, along with all of their ancestors.
+func (p *Promotion) appliesTo(herb *Herb, categoryIDs []int64) bool {
+	if len(p.HerbIDs) == 0 && len(p.CategoryIDs) == 0 && len(p.CulinaryUses) == 0 {
+		return true
+	}
+
+	for _, id := range p.HerbIDs {
+		if id == herb.ID {
+			return true
+		}
+	}
+
+	for _, id := range p.CategoryIDs {
+		for _, categoryID := range categoryIDs {
+			if id == categoryID {
+				return true
+			}
+		}
+	}
+
+	for _, use := range p.CulinaryUses {
+		if validator.In(use, herb.CulinaryUses...) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// discount returns how much the promotion takes off amount, which must be in the
+// converter's currency. It never takes off more than the whole amount.
+func (p *Promotion) discount(amount Price, converter *Converter) (Price, error) {
+	if p.Kind == PromotionPercent {
+		return amount.Percent(int64(p.PercentOff)), nil
+	}
+
+	off, err := converter.Convert(*p.AmountOff)
+	if err != nil {
+		return Price{}, err
+	}
+
+	if off.Amount > amount.Amount {
+		return amount, nil
+	}
+
+	return off, nil
+}
+
+type PromotionModel struct {
+	DB *sql.DB
+}
+
+func (m PromotionModel) Insert(p *Promotion) error {
+	query := `INSERT INTO promotions (code, name, kind, percent_off, amount_off_amount, amount_off_currency,
+			min_order_amount, min_order_currency, starts_at, expires_at, herb_ids, category_ids, culinary_uses,
+			max_uses, max_uses_per_user, active)
+		VALUES (NULLIF($1, ''), $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, promotionArgs(p)...).Scan(&p.ID, &p.CreatedAt, &p.Version)
+	if err != nil {
+		return promotionError(err)
+	}
+
+	return nil
+}
+
+func (m PromotionModel) Get(id int64) (*Promotion, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + promotionColumns + `
+		FROM promotions
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	p, err := scanPromotion(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// GetAll returns a page of promotions. kind is "coupon" or "sale" to return only one of
+// the two, or empty for both.
+func (m PromotionModel) GetAll(kind string, filters Filters) ([]*Promotion, Metadata, error) {
+	query := fmt.Sprintf(`SELECT count(*) OVER(), `+promotionColumns+`
+		FROM promotions
+		WHERE ($1 = '' OR ($1 = 'coupon') = (code IS NOT NULL))
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, kind, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	promotions := []*Promotion{}
+
+	for rows.Next() {
+		p, err := scanPromotion(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		promotions = append(promotions, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return promotions, metadata, nil
+}
+
+func (m PromotionModel) Update(p *Promotion) error {
+	query := `UPDATE promotions
+		SET code = NULLIF($1, ''), name = $2, kind = $3, percent_off = $4, amount_off_amount = $5, amount_off_currency = $6,
+			min_order_amount = $7, min_order_currency = $8, starts_at = $9, expires_at = $10, herb_ids = $11,
+			category_ids = $12, culinary_uses = $13, max_uses = $14, max_uses_per_user = $15, active = $16,
+			version = version + 1
+		WHERE id = $17 AND version = $18
+		RETURNING version`
+
+	args := append(promotionArgs(p), p.ID, p.Version)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&p.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return promotionError(err)
+		}
+	}
+
+	return nil
+}
+
+func (m PromotionModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM promotions
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// ApplySales sets SalePrice on each of the herbs that has a sale running.
+func (m PromotionModel) ApplySales(herbs ...*Herb) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return applySales(ctx, m.DB, herbs)
+}
+
+// Quote works out what the coupon would take off the user's cart in the given currency,
+// applying the same checks as checkout but without redeeming it.
+func (m PromotionModel) Quote(userID int64, code string, currency string) (*CouponQuote, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	items, err := getCartItems(ctx, m.DB, userID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items) == 0 {
+		return nil, ErrEmptyCart
+	}
+
+	converter, err := loadConverter(ctx, m.DB, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	herbs := make([]*Herb, len(items))
+	for i, item := range items {
+		herbs[i], err = getHerb(ctx, m.DB, item.HerbID, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = applySales(ctx, m.DB, herbs)
+	if err != nil {
+		return nil, err
+	}
+
+	lines, subtotal, err := priceOrderLines(items, herbs, converter)
+	if err != nil {
+		return nil, err
+	}
+
+	promotion, err := getCoupon(ctx, m.DB, code, false)
+	if err != nil {
+		return nil, err
+	}
+
+	discount, err := couponDiscount(ctx, m.DB, promotion, userID, lines, herbs, subtotal, converter)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := subtotal.Sub(discount)
+	if err != nil {
+		return nil, err
+	}
+
+	quote := &CouponQuote{
+		Code:     promotion.Code,
+		Subtotal: subtotal,
+		Discount: discount,
+		Total:    total,
+	}
+
+	return quote, nil
+}
+
+const promotionColumns = `id, created_at, coalesce(code, ''), name, kind, percent_off, amount_off_amount, amount_off_currency,
+			min_order_amount, min_order_currency, starts_at, expires_at, herb_ids, category_ids, culinary_uses,
+			max_uses, max_uses_per_user, (SELECT count(*) FROM promotion_redemptions WHERE promotion_id = promotions.id),
+			active, version`
+
+// scanPromotion scans a row selected with promotionColumns, after any leading
+// destinations such as a window count.
+func scanPromotion(row interface{ Scan(...interface{}) error }, leading ...interface{}) (*Promotion, error) {
+	var (
+		p                                   Promotion
+		amountOff, minOrder                 sql.NullInt64
+		amountOffCurrency, minOrderCurrency sql.NullString
+	)
+
+	dest := append(leading,
+		&p.ID,
+		&p.CreatedAt,
+		&p.Code,
+		&p.Name,
+		&p.Kind,
+		&p.PercentOff,
+		&amountOff,
+		&amountOffCurrency,
+		&minOrder,
+		&minOrderCurrency,
+		&p.StartsAt,
+		&p.ExpiresAt,
+		pq.Array(&p.HerbIDs),
+		pq.Array(&p.CategoryIDs),
+		pq.Array(&p.CulinaryUses),
+		&p.MaxUses,
+		&p.MaxUsesPerUser,
+		&p.Uses,
+		&p.Active,
+		&p.Version,
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	if amountOff.Valid {
+		p.AmountOff = &Price{Amount: amountOff.Int64, Currency: amountOffCurrency.String}
+	}
+	if minOrder.Valid {
+		p.MinOrderValue = &Price{Amount: minOrder.Int64, Currency: minOrderCurrency.String}
+	}
+
+	return &p, nil
+}
+
+// promotionArgs returns the values of the promotion's editable columns, in the order
+// Insert and Update expect them.
+func promotionArgs(p *Promotion) []interface{} {
+	var (
+		amountOff, minOrder                 sql.NullInt64
+		amountOffCurrency, minOrderCurrency sql.NullString
+	)
+
+	if p.AmountOff != nil {
+		amountOff = sql.NullInt64{Int64: p.AmountOff.Amount, Valid: true}
+		amountOffCurrency = sql.NullString{String: p.AmountOff.Currency, Valid: true}
+	}
+	if p.MinOrderValue != nil {
+		minOrder = sql.NullInt64{Int64: p.MinOrderValue.Amount, Valid: true}
+		minOrderCurrency = sql.NullString{String: p.MinOrderValue.Currency, Valid: true}
+	}
+
+	return []interface{}{
+		p.Code,
+		p.Name,
+		p.Kind,
+		p.PercentOff,
+		amountOff,
+		amountOffCurrency,
+		minOrder,
+		minOrderCurrency,
+		p.StartsAt,
+		p.ExpiresAt,
+		pq.Array(p.HerbIDs),
+		pq.Array(p.CategoryIDs),
+		pq.Array(p.CulinaryUses),
+		p.MaxUses,
+		p.MaxUsesPerUser,
+		p.Active,
+	}
+}
+
+// promotionError maps constraint violations on promotions to our own errors.
+func promotionError(err error) error {
+	switch {
+	case err.Error() == `pq: duplicate key value violates unique constraint "promotions_code_key"`:
+		return ErrDuplicateCouponCode
+	default:
+		return err
+	}
+}
+
+// getCoupon fetches the coupon with the given code, returning ErrInvalidCoupon if there
+// isn't one. If lock is true the row is locked FOR UPDATE, which serialises checkouts
+// using the coupon so that its limits can't be overshot.
+func getCoupon(ctx context.Context, q dbtx, code string, lock bool) (*Promotion, error) {
+	query := `SELECT ` + promotionColumns + `
+		FROM promotions
+		WHERE code = $1`
+
+	if lock {
+		query += ` FOR UPDATE`
+	}
+
+	p, err := scanPromotion(q.QueryRowContext(ctx, query, code))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrInvalidCoupon
+		default:
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// couponDiscount checks that the coupon can be used by the user on an order made up of
+// lines, whose herbs are given in the same order, and returns the discount it gives.
+// The discount only counts the lines the coupon applies to.
+func couponDiscount(ctx context.Context, q dbtx, p *Promotion, userID int64, lines []*OrderLine, herbs []*Herb, subtotal Price, converter *Converter) (Price, error) {
+	now := time.Now()
+
+	if !p.Active || now.Before(p.StartsAt) {
+		return Price{}, ErrInvalidCoupon
+	}
+
+	if p.ExpiresAt != nil && !now.Before(*p.ExpiresAt) {
+		return Price{}, ErrCouponExpired
+	}
+
+	if p.MaxUses > 0 || p.MaxUsesPerUser > 0 {
+		query := `SELECT count(*), count(*) FILTER (WHERE user_id = $2)
+			FROM promotion_redemptions
+			WHERE promotion_id = $1`
+
+		var uses, userUses int32
+
+		err := q.QueryRowContext(ctx, query, p.ID, userID).Scan(&uses, &userUses)
+		if err != nil {
+			return Price{}, err
+		}
+
+		if (p.MaxUses > 0 && uses >= p.MaxUses) || (p.MaxUsesPerUser > 0 && userUses >= p.MaxUsesPerUser) {
+			return Price{}, ErrCouponLimitReached
+		}
+	}
+
+	if p.MinOrderValue != nil {
+		minimum, err := converter.Convert(*p.MinOrderValue)
+		if err != nil {
+			return Price{}, err
+		}
+
+		if subtotal.Amount < minimum.Amount {
+			return Price{}, ErrCouponMinOrderValue
+		}
+	}
+
+	ids := make([]int64, len(herbs))
+	for i, herb := range herbs {
+		ids[i] = herb.ID
+	}
+
+	categoryIDs, err := herbCategoryIDs(ctx, q, ids)
+	if err != nil {
+		return Price{}, err
+	}
+
+	eligible := Price{Currency: subtotal.Currency}
+	matched := false
+
+	for i, line := range lines {
+		if !p.appliesTo(herbs[i], categoryIDs[herbs[i].ID]) {
+			continue
+		}
+
+		eligible, err = eligible.Add(line.LineTotal)
+		if err != nil {
+			return Price{}, err
+		}
+		matched = true
+	}
+
+	if !matched {
+		return Price{}, ErrCouponNotApplicable
+	}
+
+	return p.discount(eligible, converter)
+}
+
+// applySales sets SalePrice on each herb which has a sale running, using whichever
+// sale gives the lowest price. Sales whose amount can't be converted into the herb's
+// currency are skipped.
+func applySales(ctx context.Context, q dbtx, herbs []*Herb) error {
+	if len(herbs) == 0 {
+		return nil
+	}
+
+	query := `SELECT ` + promotionColumns + `
+		FROM promotions
+		WHERE code IS NULL AND active AND starts_at <= NOW() AND (expires_at IS NULL OR expires_at > NOW())`
+
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	sales := []*Promotion{}
+
+	for rows.Next() {
+		p, err := scanPromotion(rows)
+		if err != nil {
+			return err
+		}
+
+		sales = append(sales, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(sales) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(herbs))
+	for i, herb := range herbs {
+		ids[i] = herb.ID
+	}
+
+	categoryIDs, err := herbCategoryIDs(ctx, q, ids)
+	if err != nil {
+		return err
+	}
+
+	converters := make(map[string]*Converter)
+
+	for _, herb := range herbs {
+		herb.SalePrice = nil
+
+		converter, ok := converters[herb.Price.Currency]
+		if !ok {
+			converter, err = loadConverter(ctx, q, herb.Price.Currency)
+			if err != nil {
+				return err
+			}
+			converters[herb.Price.Currency] = converter
+		}
+
+		best := herb.Price
+
+		for _, sale := range sales {
+			if !sale.appliesTo(herb, categoryIDs[herb.ID]) {
+				continue
+			}
+
+			discount, err := sale.discount(herb.Price, converter)
+			if err != nil {
+				if errors.Is(err, ErrExchangeRateNotFound) {
+					continue
+				}
+				return err
+			}
+
+			if herb.Price.Amount-discount.Amount < best.Amount {
+				best.Amount = herb.Price.Amount - discount.Amount
+			}
+		}
+
+		if best != herb.Price {
+			herb.SalePrice = &best
+		}
+	}
+
+	return nil
+}
+
+// herbCategoryIDs returns the categories each of the herbs is filed under, together with
+// all of their ancestors, so that a promotion on a category covers its subcategories.
+func herbCategoryIDs(ctx context.Context, q dbtx, herbIDs []int64) (map[int64][]int64, error) {
+	query := `WITH RECURSIVE filed AS (
+			SELECT herb_id, category_id FROM herbs_categories WHERE herb_id = ANY($1)
+			UNION
+			SELECT filed.herb_id, categories.parent_id FROM filed
+			INNER JOIN categories ON categories.id = filed.category_id
+			WHERE categories.parent_id IS NOT NULL
+		)
+		SELECT herb_id, category_id FROM filed`
+
+	rows, err := q.QueryContext(ctx, query, pq.Array(herbIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categoryIDs := make(map[int64][]int64)
+
+	for rows.Next() {
+		var herbID, categoryID int64
+
+		err := rows.Scan(&herbID, &categoryID)
+		if err != nil {
+			return nil, err
+		}
+
+		categoryIDs[herbID] = append(categoryIDs[herbID], categoryID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return categoryIDs, nil
+}
@@ -0,0 +1,414 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+var (
+	ErrInvalidStatusTransition = errors.New("invalid status transition")
+)
+
+const (
+	PurchaseOrderDraft    = "draft"
+	PurchaseOrderSent     = "sent"
+	PurchaseOrderReceived = "received"
+)
+
+var PurchaseOrderStatuses = []string{PurchaseOrderDraft, PurchaseOrderSent, PurchaseOrderReceived}
+
+// purchaseOrderTransitions lists the statuses a purchase order may move to from each
+// status. Received orders are final.
+var purchaseOrderTransitions = map[string][]string{
+	PurchaseOrderDraft: {PurchaseOrderSent},
+	PurchaseOrderSent:  {PurchaseOrderReceived},
+}
+
+type PurchaseOrder struct {
+	ID         int64                `json:"id"`
+	CreatedAt  time.Time            `json:"created_at"`
+	SupplierID int64                `json:"supplier_id"`
+	Status     string               `json:"status"`
+	SentAt     *time.Time           `json:"sent_at,omitempty"`
+	ReceivedAt *time.Time           `json:"received_at,omitempty"`
+	Lines      []*PurchaseOrderLine `json:"lines"`
+	Version    int32                `json:"version"`
+}
+
+// PurchaseOrderLine is a quantity of one herb on a purchase order. The herb name is
+// copied onto the line so that it survives the herb later being deleted.
+type PurchaseOrderLine struct {
+	ID       int64  `json:"id"`
+	HerbID   int64  `json:"herb_id,omitempty"`
+	HerbName string `json:"herb_name"`
+	Quantity int64  `json:"quantity"` // In the herb's stock unit
+	UnitCost Price  `json:"unit_cost"`
+}
+
+// CanTransition returns true if the purchase order may move to the given status.
+func (po *PurchaseOrder) CanTransition(status string) bool {
+	return validator.In(status, purchaseOrderTransitions[po.Status]...)
+}
+
+func ValidatePurchaseOrder(v *validator.Validator, po *PurchaseOrder) {
+	v.Check(po.SupplierID > 0, "supplier_id", "must be provided")
+
+	v.Check(po.Lines != nil, "lines", "must be provided")
+	v.Check(len(po.Lines) >= 1, "lines", "must contain at least 1 line")
+	v.Check(len(po.Lines) <= 200, "lines", "must not contain more than 200 lines")
+
+	herbIDs := make([]int64, 0, len(po.Lines))
+	for i, line := range po.Lines {
+		key := fmt.Sprintf("lines[%d]", i)
+		if line == nil {
+			v.AddError(key, "must be provided")
+			continue
+		}
+
+		v.Check(line.HerbID > 0, key+".herb_id", "must be provided")
+		v.Check(line.Quantity > 0, key+".quantity", "must be greater than zero")
+		ValidatePrice(v, key+".unit_cost", line.UnitCost)
+
+		herbIDs = append(herbIDs, line.HerbID)
+	}
+
+	v.Check(uniqueIDs(herbIDs), "lines", "must not contain the same herb more than once")
+}
+
+type PurchaseOrderModel struct {
+	DB *sql.DB
+}
+
+func (m PurchaseOrderModel) Insert(po *PurchaseOrder) error {
+	query := `INSERT INTO purchase_orders (supplier_id)
+		VALUES ($1)
+		RETURNING id, created_at, status, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, po.SupplierID).Scan(&po.ID, &po.CreatedAt, &po.Status, &po.Version)
+	if err != nil {
+		return purchaseOrderError(err)
+	}
+
+	err = insertPurchaseOrderLines(ctx, tx, po)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m PurchaseOrderModel) Get(id int64) (*PurchaseOrder, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, created_at, supplier_id, status, sent_at, received_at, version
+		FROM purchase_orders
+		WHERE id = $1`
+
+	var po PurchaseOrder
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&po.ID,
+		&po.CreatedAt,
+		&po.SupplierID,
+		&po.Status,
+		&po.SentAt,
+		&po.ReceivedAt,
+		&po.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query = `SELECT id, coalesce(herb_id, 0), herb_name, quantity, unit_cost_amount, unit_cost_currency
+		FROM purchase_order_lines
+		WHERE purchase_order_id = $1
+		ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	po.Lines = []*PurchaseOrderLine{}
+
+	for rows.Next() {
+		var line PurchaseOrderLine
+
+		err := rows.Scan(
+			&line.ID,
+			&line.HerbID,
+			&line.HerbName,
+			&line.Quantity,
+			&line.UnitCost.Amount,
+			&line.UnitCost.Currency,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		po.Lines = append(po.Lines, &line)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &po, nil
+}
+
+// GetAll returns a page of purchase orders without their lines, optionally filtered by
+// supplier and status.
+func (m PurchaseOrderModel) GetAll(supplierID int64, status string, filters Filters) ([]*PurchaseOrder, Metadata, error) {
+	query := fmt.Sprintf(`SELECT count(*) OVER(), id, created_at, supplier_id, status, sent_at, received_at, version
+		FROM purchase_orders
+		WHERE (supplier_id = $1 OR $1 = 0)
+		AND (status = $2 OR $2 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{supplierID, status, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	orders := []*PurchaseOrder{}
+
+	for rows.Next() {
+		var po PurchaseOrder
+
+		err := rows.Scan(
+			&totalRecords,
+			&po.ID,
+			&po.CreatedAt,
+			&po.SupplierID,
+			&po.Status,
+			&po.SentAt,
+			&po.ReceivedAt,
+			&po.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		orders = append(orders, &po)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return orders, metadata, nil
+}
+
+// Update replaces the supplier and lines of a draft purchase order. Orders which have
+// been sent can no longer be edited, so those return ErrEditConflict.
+func (m PurchaseOrderModel) Update(po *PurchaseOrder) error {
+	query := `UPDATE purchase_orders
+		SET supplier_id = $1, version = version + 1
+		WHERE id = $2 AND version = $3 AND status = 'draft'
+		RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, po.SupplierID, po.ID, po.Version).Scan(&po.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return purchaseOrderError(err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM purchase_order_lines WHERE purchase_order_id = $1`, po.ID)
+	if err != nil {
+		return err
+	}
+
+	err = insertPurchaseOrderLines(ctx, tx, po)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a purchase order, so long as it is still a draft.
+func (m PurchaseOrderModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM purchase_orders
+		WHERE id = $1 AND status = 'draft'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Transition moves a purchase order to a new status. When an order is received, a
+// receive stock movement is recorded for every line in the same transaction, so stock
+// is only ever booked in once per order. The stock changes are audited against actor.
+func (m PurchaseOrderModel) Transition(po *PurchaseOrder, status string, actor Actor) error {
+	if !po.CanTransition(status) {
+		return ErrInvalidStatusTransition
+	}
+
+	query := `UPDATE purchase_orders
+		SET status = $1,
+			sent_at = CASE WHEN $1 = 'sent' THEN NOW() ELSE sent_at END,
+			received_at = CASE WHEN $1 = 'received' THEN NOW() ELSE received_at END,
+			version = version + 1
+		WHERE id = $2 AND version = $3 AND status = $4
+		RETURNING sent_at, received_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, status, po.ID, po.Version, po.Status).Scan(&po.SentAt, &po.ReceivedAt, &po.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if status == PurchaseOrderReceived {
+		for _, line := range po.Lines {
+			// Lines whose herb has since been deleted have nothing left to stock.
+			if line.HerbID == 0 {
+				continue
+			}
+
+			// Herbs in the trash are skipped like deleted ones.
+			_, err := getHerb(ctx, tx, line.HerbID, true)
+			if err != nil {
+				switch {
+				case errors.Is(err, ErrRecordNotFound):
+					continue
+				default:
+					return err
+				}
+			}
+
+			movement := &StockMovement{
+				HerbID:   line.HerbID,
+				Kind:     StockMovementReceive,
+				Quantity: line.Quantity,
+				Note:     fmt.Sprintf("purchase order #%d", po.ID),
+				UserID:   actor.UserID,
+			}
+
+			err = insertStockMovement(ctx, tx, movement, nil, actor)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	po.Status = status
+	return nil
+}
+
+// insertPurchaseOrderLines stores po.Lines, copying the current herb names onto them.
+func insertPurchaseOrderLines(ctx context.Context, tx *sql.Tx, po *PurchaseOrder) error {
+	query := `INSERT INTO purchase_order_lines (purchase_order_id, herb_id, herb_name, quantity, unit_cost_amount, unit_cost_currency)
+		SELECT $1, herbs.id, herbs.name, $3, $4, $5 FROM herbs WHERE herbs.id = $2 AND herbs.deleted_at IS NULL
+		RETURNING id, herb_name`
+
+	for _, line := range po.Lines {
+		args := []interface{}{po.ID, line.HerbID, line.Quantity, line.UnitCost.Amount, line.UnitCost.Currency}
+
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&line.ID, &line.HerbName)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrUnknownHerb
+			default:
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// purchaseOrderError maps foreign key violations on purchase_orders to our own errors.
+func purchaseOrderError(err error) error {
+	switch {
+	case err.Error() == `pq: insert or update on table "purchase_orders" violates foreign key constraint "purchase_orders_supplier_id_fkey"`:
+		return ErrUnknownSupplier
+	default:
+		return err
+	}
+}
@@ -0,0 +1,268 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+var (
+	ErrDuplicateReview = errors.New("duplicate review")
+)
+
+// Review is a user's star rating of a herb. Each user may review a herb once. Hidden
+// reviews are kept, but are left out of listings and the herb's rating.
+type Review struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	HerbID    int64     `json:"herb_id"`
+	UserID    int64     `json:"user_id"`
+	UserName  string    `json:"user_name"`
+	Rating    int32     `json:"rating"` // 1 to 5 stars
+	Body      string    `json:"body"`
+	Hidden    bool      `json:"hidden,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating >= 1 && review.Rating <= 5, "rating", "must be between 1 and 5")
+
+	v.Check(review.Body != "", "body", "must be provided")
+	v.Check(len(review.Body) <= 5000, "body", "must not be more than 5000 bytes long")
+}
+
+type ReviewModel struct {
+	DB *sql.DB
+}
+
+func (m ReviewModel) Insert(review *Review) error {
+	query := `INSERT INTO reviews (herb_id, user_id, rating, body)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at, (SELECT name FROM users WHERE id = $2), version`
+
+	args := []interface{}{review.HerbID, review.UserID, review.Rating, review.Body}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.UpdatedAt, &review.UserName, &review.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "reviews_herb_id_user_id_key"`:
+			return ErrDuplicateReview
+		case err.Error() == `pq: insert or update on table "reviews" violates foreign key constraint "reviews_herb_id_fkey"`:
+			return ErrUnknownHerb
+		default:
+			return err
+		}
+	}
+
+	err = updateHerbRating(ctx, tx, review.HerbID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m ReviewModel) Get(id int64) (*Review, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT reviews.id, reviews.created_at, reviews.updated_at, reviews.herb_id, reviews.user_id, users.name,
+			reviews.rating, reviews.body, reviews.hidden, reviews.version
+		FROM reviews
+		INNER JOIN users ON users.id = reviews.user_id
+		WHERE reviews.id = $1`
+
+	var review Review
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.HerbID,
+		&review.UserID,
+		&review.UserName,
+		&review.Rating,
+		&review.Body,
+		&review.Hidden,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+// GetAllForHerb returns a page of the herb's reviews. Hidden reviews are only included
+// if includeHidden is true.
+func (m ReviewModel) GetAllForHerb(herbID int64, includeHidden bool, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`SELECT count(*) OVER(), reviews.id, reviews.created_at, reviews.updated_at, reviews.herb_id, reviews.user_id,
+			users.name, reviews.rating, reviews.body, reviews.hidden, reviews.version
+		FROM reviews
+		INNER JOIN users ON users.id = reviews.user_id
+		WHERE reviews.herb_id = $1 AND (NOT reviews.hidden OR $2)
+		ORDER BY reviews.%s %s, reviews.id ASC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, herbID, includeHidden, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.HerbID,
+			&review.UserID,
+			&review.UserName,
+			&review.Rating,
+			&review.Body,
+			&review.Hidden,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return reviews, metadata, nil
+}
+
+// Update saves the review's rating, body and hidden flag, and refreshes the herb's
+// rating in the same transaction.
+func (m ReviewModel) Update(review *Review) error {
+	query := `UPDATE reviews
+		SET rating = $1, body = $2, hidden = $3, updated_at = NOW(), version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING updated_at, version`
+
+	args := []interface{}{review.Rating, review.Body, review.Hidden, review.ID, review.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&review.UpdatedAt, &review.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = updateHerbRating(ctx, tx, review.HerbID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m ReviewModel) Delete(review *Review) error {
+	query := `DELETE FROM reviews
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, review.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = updateHerbRating(ctx, tx, review.HerbID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// updateHerbRating recalculates the herb's average rating and review count from its
+// visible reviews. It is called in the same transaction as every change to a review,
+// so herb listings can read and sort by the stored values without aggregating. The
+// herb's version is left alone, since reviews aren't edits to the herb itself.
+func updateHerbRating(ctx context.Context, tx *sql.Tx, herbID int64) error {
+	// Lock the herb first, so that the update below runs with a snapshot that includes
+	// any review committed by a concurrent transaction which held the lock before us.
+	_, err := tx.ExecContext(ctx, `SELECT 1 FROM herbs WHERE id = $1 FOR UPDATE`, herbID)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE herbs
+		SET (average_rating, review_count) = (
+			SELECT coalesce(round(avg(rating), 2), 0), count(*)
+			FROM reviews
+			WHERE herb_id = $1 AND NOT hidden
+		)
+		WHERE id = $1`
+
+	_, err = tx.ExecContext(ctx, query, herbID)
+	return err
+}
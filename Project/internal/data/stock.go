@@ -0,0 +1,262 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+var (
+	ErrInsufficientStock = errors.New("insufficient stock")
+)
+
+const (
+	StockMovementReceive = "receive"
+	StockMovementAdjust  = "adjust"
+	StockMovementSell    = "sell"
+)
+
+var (
+	StockMovementKinds = []string{StockMovementReceive, StockMovementAdjust, StockMovementSell}
+	StockUnits         = []string{"grams", "jars"}
+)
+
+// Stock is the stock-on-hand view of a herb, or of one of its variants when VariantID is
+// set. Stock has a version of its own, apart from the herb's or variant's, so that sales
+// don't get in the way of edits to them.
+type Stock struct {
+	HerbID    int64  `json:"herb_id"`
+	VariantID int64  `json:"variant_id,omitempty"`
+	Quantity  int64  `json:"quantity"`
+	Unit      string `json:"unit"`
+	Version   int32  `json:"version"`
+}
+
+// StockMovement is an entry in the append-only stock ledger. Quantity is the signed
+// change it made to the herb's stock, so sales are recorded as negative quantities.
+// Movements of a variant's stock of packs have VariantID set.
+type StockMovement struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	HerbID       int64     `json:"herb_id"`
+	VariantID    int64     `json:"variant_id,omitempty"`
+	Kind         string    `json:"kind"`
+	Quantity     int64     `json:"quantity"`
+	Balance      int64     `json:"balance"` // Stock on hand after the movement
+	Note         string    `json:"note,omitempty"`
+	UserID       int64     `json:"user_id,omitempty"` // User who recorded the movement
+	StockVersion int32     `json:"stock_version"`     // Stock version the movement produced
+}
+
+func ValidateStockMovement(v *validator.Validator, movement *StockMovement) {
+	v.Check(validator.In(movement.Kind, StockMovementKinds...), "kind", "must be one of receive, adjust, sell")
+
+	v.Check(movement.Quantity != 0, "quantity", "must not be zero")
+	switch movement.Kind {
+	case StockMovementReceive:
+		v.Check(movement.Quantity > 0, "quantity", "must be greater than zero")
+	case StockMovementSell:
+		// Sales are stored as negative quantities, but clients send the number sold.
+		v.Check(movement.Quantity < 0, "quantity", "must be greater than zero")
+	}
+
+	v.Check(len(movement.Note) <= 500, "note", "must not be more than 500 bytes long")
+}
+
+type StockMovementModel struct {
+	DB *sql.DB
+}
+
+// GetStock returns the stock on hand of a herb, or if variantID isn't 0 of that variant
+// of the herb. Variants are counted in packs.
+func (m StockMovementModel) GetStock(herbID, variantID int64) (*Stock, error) {
+	if herbID < 1 || variantID < 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, 0, stock_quantity, stock_unit, stock_version
+		FROM herbs
+		WHERE id = $1 AND deleted_at IS NULL AND $2 = 0`
+
+	if variantID != 0 {
+		query = `SELECT herb_variants.herb_id, herb_variants.id, herb_variants.stock_quantity, 'packs', herb_variants.stock_version
+			FROM herb_variants
+			INNER JOIN herbs ON herbs.id = herb_variants.herb_id
+			WHERE herbs.id = $1 AND herbs.deleted_at IS NULL AND herb_variants.id = $2`
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var stock Stock
+
+	err := m.DB.QueryRowContext(ctx, query, herbID, variantID).Scan(
+		&stock.HerbID,
+		&stock.VariantID,
+		&stock.Quantity,
+		&stock.Unit,
+		&stock.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &stock, nil
+}
+
+// Insert records a stock movement and applies it to the stock on hand in a single
+// transaction, along with an audit event against actor. It only succeeds if the stock
+// is still at the given version, returning ErrEditConflict otherwise.
+func (m StockMovementModel) Insert(movement *StockMovement, version int32, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertStockMovement(ctx, tx, movement, &version, actor)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertStockMovement is Insert as one step of the transaction tx. A nil version applies
+// the movement whatever the stock's version, for callers which have already locked the
+// herb or variant.
+func insertStockMovement(ctx context.Context, tx *sql.Tx, movement *StockMovement, version *int32, actor Actor) error {
+	query := `UPDATE herbs
+		SET stock_quantity = stock_quantity + $1, stock_version = stock_version + 1
+		WHERE id = $2 AND ($3::integer IS NULL OR stock_version = $3)
+		RETURNING stock_quantity, stock_version`
+
+	args := []interface{}{movement.Quantity, movement.HerbID, version}
+
+	if movement.VariantID != 0 {
+		query = `UPDATE herb_variants
+			SET stock_quantity = stock_quantity + $1, stock_version = stock_version + 1
+			WHERE herb_id = $2 AND ($3::integer IS NULL OR stock_version = $3) AND id = $4
+			RETURNING stock_quantity, stock_version`
+
+		args = append(args, movement.VariantID)
+	}
+
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&movement.Balance, &movement.StockVersion)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: new row for relation "herbs" violates check constraint "herbs_stock_quantity_check"`,
+			err.Error() == `pq: new row for relation "herb_variants" violates check constraint "herb_variants_stock_quantity_check"`:
+			return ErrInsufficientStock
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	query = `INSERT INTO stock_movements (herb_id, variant_id, kind, quantity, balance, note, user_id, stock_version)
+		VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6, NULLIF($7, 0), $8)
+		RETURNING id, created_at`
+
+	args = []interface{}{
+		movement.HerbID,
+		movement.VariantID,
+		movement.Kind,
+		movement.Quantity,
+		movement.Balance,
+		movement.Note,
+		movement.UserID,
+		movement.StockVersion,
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movement.ID, &movement.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	before, err := json.Marshal(movement.Balance - movement.Quantity)
+	if err != nil {
+		return err
+	}
+
+	after, err := json.Marshal(movement.Balance)
+	if err != nil {
+		return err
+	}
+
+	field := "stock_quantity"
+	if movement.VariantID != 0 {
+		field = fmt.Sprintf("variants.%d.stock_quantity", movement.VariantID)
+	}
+
+	changes := map[string]AuditChange{field: {Before: before, After: after}}
+
+	return insertAuditChanges(ctx, tx, actor, "update", "herb", movement.HerbID, changes)
+}
+
+// GetAllForHerb returns a page of the movements of a herb's stock, or if variantID isn't 0
+// of that variant's stock.
+func (m StockMovementModel) GetAllForHerb(herbID, variantID int64, filters Filters) ([]*StockMovement, Metadata, error) {
+	query := fmt.Sprintf(`SELECT count(*) OVER(), id, created_at, herb_id, coalesce(variant_id, 0), kind, quantity, balance, note, coalesce(user_id, 0), stock_version
+		FROM stock_movements
+		WHERE herb_id = $1 AND coalesce(variant_id, 0) = $2
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, herbID, variantID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movements := []*StockMovement{}
+
+	for rows.Next() {
+		var movement StockMovement
+
+		err := rows.Scan(
+			&totalRecords,
+			&movement.ID,
+			&movement.CreatedAt,
+			&movement.HerbID,
+			&movement.VariantID,
+			&movement.Kind,
+			&movement.Quantity,
+			&movement.Balance,
+			&movement.Note,
+			&movement.UserID,
+			&movement.StockVersion,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movements = append(movements, &movement)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movements, metadata, nil
+}
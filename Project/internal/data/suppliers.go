@@ -0,0 +1,311 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+var (
+	ErrSupplierInUse   = errors.New("supplier in use")
+	ErrUnknownSupplier = errors.New("unknown supplier")
+)
+
+var CountryRX = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// Supplier is a grower or wholesaler we buy herbs from.
+type Supplier struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"-"`
+	Name         string    `json:"name"`
+	ContactName  string    `json:"contact_name,omitempty"`
+	Email        string    `json:"email,omitempty"`
+	Phone        string    `json:"phone,omitempty"`
+	Country      string    `json:"country"`        // ISO 3166-1 alpha-2 code
+	LeadTimeDays int32     `json:"lead_time_days"` // Typical days between ordering and delivery
+	HerbIDs      []int64   `json:"herb_ids"`       // Herbs the supplier can provide
+	Version      int32     `json:"version"`
+}
+
+func ValidateSupplier(v *validator.Validator, supplier *Supplier) {
+	v.Check(supplier.Name != "", "name", "must be provided")
+	v.Check(len(supplier.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	v.Check(len(supplier.ContactName) <= 500, "contact_name", "must not be more than 500 bytes long")
+
+	if supplier.Email != "" {
+		ValidateEmail(v, supplier.Email)
+	}
+
+	v.Check(len(supplier.Phone) <= 50, "phone", "must not be more than 50 bytes long")
+
+	v.Check(supplier.Country != "", "country", "must be provided")
+	v.Check(validator.Matches(supplier.Country, CountryRX), "country", "must be an ISO 3166-1 alpha-2 country code")
+
+	v.Check(supplier.LeadTimeDays >= 0, "lead_time_days", "must not be negative")
+	v.Check(supplier.LeadTimeDays <= 365, "lead_time_days", "must not be more than 365 days")
+
+	v.Check(supplier.HerbIDs != nil, "herb_ids", "must be provided")
+	v.Check(uniqueIDs(supplier.HerbIDs), "herb_ids", "must not contain duplicate values")
+}
+
+type SupplierModel struct {
+	DB *sql.DB
+}
+
+func (m SupplierModel) Insert(supplier *Supplier) error {
+	query := `INSERT INTO suppliers (name, contact_name, email, phone, country, lead_time_days)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, version`
+
+	args := []interface{}{
+		supplier.Name,
+		supplier.ContactName,
+		supplier.Email,
+		supplier.Phone,
+		supplier.Country,
+		supplier.LeadTimeDays,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&supplier.ID, &supplier.CreatedAt, &supplier.Version)
+	if err != nil {
+		return err
+	}
+
+	err = setSupplierHerbs(ctx, tx, supplier)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m SupplierModel) Get(id int64) (*Supplier, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, created_at, name, contact_name, email, phone, country, lead_time_days,
+			array(SELECT herb_id FROM herbs_suppliers WHERE supplier_id = suppliers.id ORDER BY herb_id), version
+		FROM suppliers
+		WHERE id = $1`
+
+	var supplier Supplier
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&supplier.ID,
+		&supplier.CreatedAt,
+		&supplier.Name,
+		&supplier.ContactName,
+		&supplier.Email,
+		&supplier.Phone,
+		&supplier.Country,
+		&supplier.LeadTimeDays,
+		pq.Array(&supplier.HerbIDs),
+		&supplier.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &supplier, nil
+}
+
+// GetAll returns a page of suppliers. The name filter uses the same full-text matching as
+// HerbModel.GetAll, and a non-zero herbID limits the results to suppliers of that herb.
+func (m SupplierModel) GetAll(name string, country string, herbID int64, filters Filters) ([]*Supplier, Metadata, error) {
+	query := fmt.Sprintf(`SELECT count(*) OVER(), id, created_at, name, contact_name, email, phone, country, lead_time_days,
+			array(SELECT herb_id FROM herbs_suppliers WHERE supplier_id = suppliers.id ORDER BY herb_id), version
+		FROM suppliers
+		WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND (country = $2 OR $2 = '')
+		AND (id IN (SELECT supplier_id FROM herbs_suppliers WHERE herb_id = $3) OR $3 = 0)
+		ORDER BY %s %s, id ASC
+		LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{name, country, herbID, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	suppliers := []*Supplier{}
+
+	for rows.Next() {
+		var supplier Supplier
+
+		err := rows.Scan(
+			&totalRecords,
+			&supplier.ID,
+			&supplier.CreatedAt,
+			&supplier.Name,
+			&supplier.ContactName,
+			&supplier.Email,
+			&supplier.Phone,
+			&supplier.Country,
+			&supplier.LeadTimeDays,
+			pq.Array(&supplier.HerbIDs),
+			&supplier.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		suppliers = append(suppliers, &supplier)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return suppliers, metadata, nil
+}
+
+func (m SupplierModel) Update(supplier *Supplier) error {
+	query := `UPDATE suppliers
+		SET name = $1, contact_name = $2, email = $3, phone = $4, country = $5, lead_time_days = $6, version = version + 1
+		WHERE id = $7 AND version = $8
+		RETURNING version`
+
+	args := []interface{}{
+		supplier.Name,
+		supplier.ContactName,
+		supplier.Email,
+		supplier.Phone,
+		supplier.Country,
+		supplier.LeadTimeDays,
+		supplier.ID,
+		supplier.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&supplier.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM herbs_suppliers WHERE supplier_id = $1`, supplier.ID)
+	if err != nil {
+		return err
+	}
+
+	err = setSupplierHerbs(ctx, tx, supplier)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m SupplierModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM suppliers
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: update or delete on table "suppliers" violates foreign key constraint "purchase_orders_supplier_id_fkey" on table "purchase_orders"`:
+			return ErrSupplierInUse
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// setSupplierHerbs links the supplier to each herb in supplier.HerbIDs, returning
+// ErrUnknownHerb if any of them don't exist.
+func setSupplierHerbs(ctx context.Context, tx *sql.Tx, supplier *Supplier) error {
+	query := `INSERT INTO herbs_suppliers (herb_id, supplier_id)
+		SELECT herbs.id, $1 FROM herbs WHERE herbs.id = ANY($2) AND herbs.deleted_at IS NULL`
+
+	result, err := tx.ExecContext(ctx, query, supplier.ID, pq.Array(supplier.HerbIDs))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != int64(len(supplier.HerbIDs)) {
+		return ErrUnknownHerb
+	}
+
+	return nil
+}
+
+// uniqueIDs returns true if all the IDs in a slice are unique.
+func uniqueIDs(ids []int64) bool {
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return false
+		}
+		seen[id] = true
+	}
+	return true
+}
@@ -1,9 +1,11 @@
 package data
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"time"
 
@@ -88,7 +90,8 @@ type UserModel struct {
 	DB *sql.DB
 }
 
-func (m UserModel) Insert(user *User) error {
+// Insert adds the user, recording it in the audit trail against actor.
+func (m UserModel) Insert(user *User, actor Actor) error {
 	query := `INSERT INTO users (name, email, password_hash, activated)
 			VALUES ($1, $2, $3, $4)
 			RETURNING id, created_at, version`
@@ -96,7 +99,13 @@ func (m UserModel) Insert(user *User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -105,7 +114,13 @@ func (m UserModel) Insert(user *User) error {
 			return err
 		}
 	}
-	return nil
+
+	err = insertAuditEvent(ctx, tx, actor, "create", "user", user.ID, nil, user)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (m UserModel) GetByEmail(email string) (*User, error) {
@@ -135,7 +150,10 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (m UserModel) Update(user *User) error {
+// Update saves the user's details, recording the changes in the audit trail against
+// actor. Password hashes are never written to the trail, only the fact that the
+// password changed.
+func (m UserModel) Update(user *User, actor Actor) error {
 	query := `
 UPDATE users
 SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
@@ -151,7 +169,34 @@ RETURNING version`
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current User
+
+	err = tx.QueryRowContext(ctx, `SELECT id, created_at, name, email, password_hash, activated, version FROM users WHERE id = $1 FOR UPDATE`, user.ID).Scan(
+		&current.ID,
+		&current.CreatedAt,
+		&current.Name,
+		&current.Email,
+		&current.Password.hash,
+		&current.Activated,
+		&current.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -162,7 +207,23 @@ RETURNING version`
 			return err
 		}
 	}
-	return nil
+
+	changes, err := auditDiff(&current, user)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(current.Password.hash, user.Password.hash) {
+		redacted := json.RawMessage(`"[redacted]"`)
+		changes["password"] = AuditChange{Before: redacted, After: redacted}
+	}
+
+	err = insertAuditChanges(ctx, tx, actor, "update", "user", user.ID, changes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
@@ -0,0 +1,282 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+
+	"gourmetspices.yerassyl.net/internal/validator"
+)
+
+var (
+	ErrDuplicateSKU     = errors.New("duplicate sku")
+	ErrDuplicateBarcode = errors.New("duplicate barcode")
+)
+
+var (
+	SKURX     = regexp.MustCompile(`^[A-Z0-9][A-Z0-9._-]*$`)
+	BarcodeRX = regexp.MustCompile(`^[0-9]{8,14}$`)
+)
+
+// HerbVariant is a sellable pack of a herb, such as a 50g pouch or a 1kg bulk bag.
+type HerbVariant struct {
+	ID            int64     `json:"id"`
+	CreatedAt     time.Time `json:"-"`
+	HerbID        int64     `json:"herb_id"`
+	SKU           string    `json:"sku"`
+	PackSizeGrams int32     `json:"pack_size_grams"` // Net weight of herb in the pack
+	Packaging     string    `json:"packaging"`       // e.g. pouch, jar, bag
+	WeightGrams   int32     `json:"weight_grams"`    // Gross shipping weight of the pack
+	Price         Price     `json:"price"`
+	Barcode       string    `json:"barcode,omitempty"`
+	StockQuantity int64     `json:"stock_quantity"` // Changed only through stock movements
+	Version       int32     `json:"version"`
+}
+
+func ValidateHerbVariant(v *validator.Validator, variant *HerbVariant) {
+	v.Check(variant.SKU != "", "sku", "must be provided")
+	v.Check(len(variant.SKU) <= 64, "sku", "must not be more than 64 bytes long")
+	v.Check(validator.Matches(variant.SKU, SKURX), "sku", "must contain only upper case letters, digits, '.', '_' and '-'")
+
+	v.Check(variant.PackSizeGrams > 0, "pack_size_grams", "must be greater than zero")
+
+	v.Check(variant.Packaging != "", "packaging", "must be provided")
+	v.Check(len(variant.Packaging) <= 50, "packaging", "must not be more than 50 bytes long")
+
+	v.Check(variant.WeightGrams > 0, "weight_grams", "must be greater than zero")
+
+	ValidatePrice(v, "price", variant.Price)
+
+	if variant.Barcode != "" {
+		v.Check(validator.Matches(variant.Barcode, BarcodeRX), "barcode", "must be a GTIN of 8 to 14 digits")
+	}
+
+	v.Check(variant.StockQuantity >= 0, "stock_quantity", "must not be negative")
+}
+
+type HerbVariantModel struct {
+	DB *sql.DB
+}
+
+// Insert adds a variant. Its opening stock is recorded as a receive stock movement in the
+// same transaction, against actor, so that the ledger accounts for all of it.
+func (m HerbVariantModel) Insert(variant *HerbVariant, actor Actor) error {
+	query := `INSERT INTO herb_variants (herb_id, sku, pack_size_grams, packaging, weight_grams, price_amount, price_currency, barcode)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''))
+		RETURNING id, created_at, version`
+
+	args := []interface{}{
+		variant.HerbID,
+		variant.SKU,
+		variant.PackSizeGrams,
+		variant.Packaging,
+		variant.WeightGrams,
+		variant.Price.Amount,
+		variant.Price.Currency,
+		variant.Barcode,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&variant.ID, &variant.CreatedAt, &variant.Version)
+	if err != nil {
+		return variantError(err)
+	}
+
+	if variant.StockQuantity > 0 {
+		movement := &StockMovement{
+			HerbID:    variant.HerbID,
+			VariantID: variant.ID,
+			Kind:      StockMovementReceive,
+			Quantity:  variant.StockQuantity,
+			Note:      "Opening stock",
+			UserID:    actor.UserID,
+		}
+
+		err = insertStockMovement(ctx, tx, movement, nil, actor)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m HerbVariantModel) Get(herbID, id int64) (*HerbVariant, error) {
+	if herbID < 1 || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, created_at, herb_id, sku, pack_size_grams, packaging, weight_grams, price_amount, price_currency, coalesce(barcode, ''), stock_quantity, version
+		FROM herb_variants
+		WHERE herb_id = $1 AND id = $2`
+
+	var variant HerbVariant
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, herbID, id).Scan(
+		&variant.ID,
+		&variant.CreatedAt,
+		&variant.HerbID,
+		&variant.SKU,
+		&variant.PackSizeGrams,
+		&variant.Packaging,
+		&variant.WeightGrams,
+		&variant.Price.Amount,
+		&variant.Price.Currency,
+		&variant.Barcode,
+		&variant.StockQuantity,
+		&variant.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &variant, nil
+}
+
+// GetAllForHerbs returns the variants of each of the given herbs, keyed by herb ID and
+// ordered by pack size.
+func (m HerbVariantModel) GetAllForHerbs(herbIDs []int64) (map[int64][]*HerbVariant, error) {
+	query := `SELECT id, created_at, herb_id, sku, pack_size_grams, packaging, weight_grams, price_amount, price_currency, coalesce(barcode, ''), stock_quantity, version
+		FROM herb_variants
+		WHERE herb_id = ANY($1)
+		ORDER BY herb_id, pack_size_grams, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(herbIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := make(map[int64][]*HerbVariant)
+
+	for rows.Next() {
+		var variant HerbVariant
+
+		err := rows.Scan(
+			&variant.ID,
+			&variant.CreatedAt,
+			&variant.HerbID,
+			&variant.SKU,
+			&variant.PackSizeGrams,
+			&variant.Packaging,
+			&variant.WeightGrams,
+			&variant.Price.Amount,
+			&variant.Price.Currency,
+			&variant.Barcode,
+			&variant.StockQuantity,
+			&variant.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		variants[variant.HerbID] = append(variants[variant.HerbID], &variant)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return variants, nil
+}
+
+// Update saves changes to a variant, apart from its stock quantity, which only changes
+// through stock movements. The variant's StockQuantity is refreshed from the database.
+func (m HerbVariantModel) Update(variant *HerbVariant) error {
+	query := `UPDATE herb_variants
+		SET sku = $1, pack_size_grams = $2, packaging = $3, weight_grams = $4, price_amount = $5, price_currency = $6,
+			barcode = NULLIF($7, ''), version = version + 1
+		WHERE id = $8 AND herb_id = $9 AND version = $10
+		RETURNING stock_quantity, version`
+
+	args := []interface{}{
+		variant.SKU,
+		variant.PackSizeGrams,
+		variant.Packaging,
+		variant.WeightGrams,
+		variant.Price.Amount,
+		variant.Price.Currency,
+		variant.Barcode,
+		variant.ID,
+		variant.HerbID,
+		variant.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&variant.StockQuantity, &variant.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return variantError(err)
+		}
+	}
+
+	return nil
+}
+
+func (m HerbVariantModel) Delete(herbID, id int64) error {
+	if herbID < 1 || id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM herb_variants
+		WHERE herb_id = $1 AND id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, herbID, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// variantError maps unique constraint violations on herb_variants to our own errors.
+func variantError(err error) error {
+	switch {
+	case err.Error() == `pq: duplicate key value violates unique constraint "herb_variants_sku_key"`:
+		return ErrDuplicateSKU
+	case err.Error() == `pq: duplicate key value violates unique constraint "herb_variants_barcode_key"`:
+		return ErrDuplicateBarcode
+	default:
+		return err
+	}
+}
@@ -48,6 +48,17 @@ func In(value string, list ...string) bool {
 	return false
 }
 
+// PermittedValues returns true if every value in a slice is in a list of permitted
+// strings.
+func PermittedValues(values []string, permitted ...string) bool {
+	for i := range values {
+		if !In(values[i], permitted...) {
+			return false
+		}
+	}
+	return true
+}
+
 // Matches returns true if a string value matches a specific regexp pattern.
 func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
@@ -0,0 +1,14 @@
+-- Prices used to be numeric(5, 2) amounts in USD. Refuse to go back if any price
+-- can't be stored that way, rather than failing part way or silently losing currencies.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM herbs WHERE price_amount > 99999 OR price_currency <> 'USD') THEN
+        RAISE EXCEPTION 'cannot revert herb prices: some are above 999.99 or not in USD';
+    END IF;
+END
+$$;
+
+ALTER TABLE herbs DROP CONSTRAINT IF EXISTS herbs_price_currency_check;
+ALTER TABLE herbs DROP COLUMN IF EXISTS price_currency;
+ALTER TABLE herbs ALTER COLUMN price_amount TYPE numeric(5, 2) USING price_amount / 100.0;
+ALTER TABLE herbs RENAME COLUMN price_amount TO price;
\ No newline at end of file
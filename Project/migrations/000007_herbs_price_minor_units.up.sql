@@ -0,0 +1,5 @@
+ALTER TABLE herbs RENAME COLUMN price TO price_amount;
+ALTER TABLE herbs ALTER COLUMN price_amount TYPE bigint USING (price_amount * 100)::bigint;
+ALTER TABLE herbs ADD COLUMN price_currency text NOT NULL DEFAULT 'USD';
+ALTER TABLE herbs ALTER COLUMN price_currency DROP DEFAULT;
+ALTER TABLE herbs ADD CONSTRAINT herbs_price_currency_check CHECK (price_currency ~ '^[A-Z]{3}$');
\ No newline at end of file
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code = 'rates:write';
+DROP TABLE IF EXISTS exchange_rates;
+ALTER TABLE herbs DROP CONSTRAINT IF EXISTS herbs_price_currency_fkey;
+DROP TABLE IF EXISTS currencies;
\ No newline at end of file
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS currencies
+(
+    code        text PRIMARY KEY,
+    name        text    NOT NULL,
+    minor_units integer NOT NULL
+);
+
+INSERT INTO currencies (code, name, minor_units)
+VALUES ('EUR', 'Euro', 2),
+       ('KZT', 'Kazakhstani tenge', 2),
+       ('RUB', 'Russian ruble', 2),
+       ('USD', 'United States dollar', 2);
+
+ALTER TABLE herbs ADD CONSTRAINT herbs_price_currency_fkey FOREIGN KEY (price_currency) REFERENCES currencies (code);
+
+CREATE TABLE IF NOT EXISTS exchange_rates
+(
+    base_currency  text                        NOT NULL REFERENCES currencies,
+    quote_currency text                        NOT NULL REFERENCES currencies,
+    rate           numeric(20, 10)             NOT NULL CHECK (rate > 0),
+    updated_at     timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (base_currency, quote_currency),
+    CHECK (base_currency <> quote_currency)
+);
+
+INSERT INTO permissions (code)
+VALUES ('rates:write');
\ No newline at end of file
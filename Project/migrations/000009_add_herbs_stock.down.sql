@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS stock_movements;
+ALTER TABLE herbs DROP CONSTRAINT IF EXISTS herbs_stock_unit_check;
+ALTER TABLE herbs DROP CONSTRAINT IF EXISTS herbs_stock_quantity_check;
+ALTER TABLE herbs DROP COLUMN IF EXISTS stock_unit;
+ALTER TABLE herbs DROP COLUMN IF EXISTS stock_quantity;
\ No newline at end of file
@@ -0,0 +1,19 @@
+ALTER TABLE herbs ADD COLUMN stock_quantity bigint NOT NULL DEFAULT 0;
+ALTER TABLE herbs ADD COLUMN stock_unit text NOT NULL DEFAULT 'grams';
+ALTER TABLE herbs ADD CONSTRAINT herbs_stock_quantity_check CHECK (stock_quantity >= 0);
+ALTER TABLE herbs ADD CONSTRAINT herbs_stock_unit_check CHECK (stock_unit IN ('grams', 'jars'));
+
+CREATE TABLE IF NOT EXISTS stock_movements
+(
+    id           bigserial PRIMARY KEY,
+    created_at   timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    herb_id      bigint                      NOT NULL REFERENCES herbs ON DELETE CASCADE,
+    kind         text                        NOT NULL CHECK (kind IN ('receive', 'adjust', 'sell')),
+    quantity     bigint                      NOT NULL CHECK (quantity <> 0),
+    balance      bigint                      NOT NULL,
+    note         text                        NOT NULL DEFAULT '',
+    user_id      bigint REFERENCES users ON DELETE SET NULL,
+    herb_version integer                     NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS stock_movements_herb_id_idx ON stock_movements (herb_id, id);
\ No newline at end of file
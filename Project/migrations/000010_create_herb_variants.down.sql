@@ -0,0 +1 @@
+DROP TABLE IF EXISTS herb_variants;
\ No newline at end of file
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS herb_variants
+(
+    id              bigserial PRIMARY KEY,
+    created_at      timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    herb_id         bigint                      NOT NULL REFERENCES herbs ON DELETE CASCADE,
+    sku             text                        NOT NULL UNIQUE,
+    pack_size_grams integer                     NOT NULL CHECK (pack_size_grams > 0),
+    packaging       text                        NOT NULL,
+    weight_grams    integer                     NOT NULL CHECK (weight_grams > 0),
+    price_amount    bigint                      NOT NULL CHECK (price_amount >= 0),
+    price_currency  text                        NOT NULL REFERENCES currencies,
+    barcode         text UNIQUE,
+    stock_quantity  bigint                      NOT NULL DEFAULT 0 CHECK (stock_quantity >= 0),
+    version         integer                     NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS herb_variants_herb_id_idx ON herb_variants (herb_id);
\ No newline at end of file
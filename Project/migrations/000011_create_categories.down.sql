@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'categories:write';
+DROP TABLE IF EXISTS herbs_categories;
+DROP TABLE IF EXISTS categories;
\ No newline at end of file
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS categories
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name       text                        NOT NULL,
+    slug       text                        NOT NULL UNIQUE,
+    parent_id  bigint REFERENCES categories ON DELETE RESTRICT,
+    version    integer                     NOT NULL DEFAULT 1,
+    CHECK (parent_id <> id)
+);
+
+CREATE INDEX IF NOT EXISTS categories_parent_id_idx ON categories (parent_id);
+
+CREATE TABLE IF NOT EXISTS herbs_categories
+(
+    herb_id     bigint NOT NULL REFERENCES herbs ON DELETE CASCADE,
+    category_id bigint NOT NULL REFERENCES categories ON DELETE CASCADE,
+    PRIMARY KEY (herb_id, category_id)
+);
+
+CREATE INDEX IF NOT EXISTS herbs_categories_category_id_idx ON herbs_categories (category_id);
+
+INSERT INTO permissions (code)
+VALUES ('categories:write');
\ No newline at end of file
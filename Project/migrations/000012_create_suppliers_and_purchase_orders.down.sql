@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code IN ('suppliers:read', 'suppliers:write', 'purchasing:read', 'purchasing:write');
+DROP TABLE IF EXISTS purchase_order_lines;
+DROP TABLE IF EXISTS purchase_orders;
+DROP TABLE IF EXISTS herbs_suppliers;
+DROP TABLE IF EXISTS suppliers;
\ No newline at end of file
@@ -0,0 +1,53 @@
+CREATE TABLE IF NOT EXISTS suppliers
+(
+    id             bigserial PRIMARY KEY,
+    created_at     timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name           text                        NOT NULL,
+    contact_name   text                        NOT NULL DEFAULT '',
+    email          text                        NOT NULL DEFAULT '',
+    phone          text                        NOT NULL DEFAULT '',
+    country        text                        NOT NULL CHECK (country ~ '^[A-Z]{2}$'),
+    lead_time_days integer                     NOT NULL CHECK (lead_time_days >= 0),
+    version        integer                     NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS herbs_suppliers
+(
+    herb_id     bigint NOT NULL REFERENCES herbs ON DELETE CASCADE,
+    supplier_id bigint NOT NULL REFERENCES suppliers ON DELETE CASCADE,
+    PRIMARY KEY (herb_id, supplier_id)
+);
+
+CREATE INDEX IF NOT EXISTS herbs_suppliers_supplier_id_idx ON herbs_suppliers (supplier_id);
+
+CREATE TABLE IF NOT EXISTS purchase_orders
+(
+    id          bigserial PRIMARY KEY,
+    created_at  timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    supplier_id bigint                      NOT NULL REFERENCES suppliers ON DELETE RESTRICT,
+    status      text                        NOT NULL DEFAULT 'draft' CHECK (status IN ('draft', 'sent', 'received')),
+    sent_at     timestamp(0) with time zone,
+    received_at timestamp(0) with time zone,
+    version     integer                     NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS purchase_orders_supplier_id_idx ON purchase_orders (supplier_id);
+
+CREATE TABLE IF NOT EXISTS purchase_order_lines
+(
+    id                 bigserial PRIMARY KEY,
+    purchase_order_id  bigint NOT NULL REFERENCES purchase_orders ON DELETE CASCADE,
+    herb_id            bigint REFERENCES herbs ON DELETE SET NULL,
+    herb_name          text   NOT NULL,
+    quantity           bigint NOT NULL CHECK (quantity > 0),
+    unit_cost_amount   bigint NOT NULL CHECK (unit_cost_amount >= 0),
+    unit_cost_currency text   NOT NULL REFERENCES currencies
+);
+
+CREATE INDEX IF NOT EXISTS purchase_order_lines_purchase_order_id_idx ON purchase_order_lines (purchase_order_id);
+
+INSERT INTO permissions (code)
+VALUES ('suppliers:read'),
+       ('suppliers:write'),
+       ('purchasing:read'),
+       ('purchasing:write');
\ No newline at end of file
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code IN ('orders:read', 'orders:write');
+DROP TABLE IF EXISTS order_lines;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS cart_items;
\ No newline at end of file
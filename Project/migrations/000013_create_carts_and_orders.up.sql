@@ -0,0 +1,43 @@
+CREATE TABLE IF NOT EXISTS cart_items
+(
+    user_id             bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    herb_id             bigint                      NOT NULL REFERENCES herbs ON DELETE CASCADE,
+    quantity            bigint                      NOT NULL CHECK (quantity > 0),
+    unit_price_amount   bigint                      NOT NULL,
+    unit_price_currency text                        NOT NULL REFERENCES currencies,
+    added_at            timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, herb_id)
+);
+
+CREATE TABLE IF NOT EXISTS orders
+(
+    id           bigserial PRIMARY KEY,
+    created_at   timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id      bigint                      NOT NULL REFERENCES users ON DELETE RESTRICT,
+    status       text                        NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'paid', 'shipped', 'cancelled')),
+    currency     text                        NOT NULL REFERENCES currencies,
+    total_amount bigint                      NOT NULL CHECK (total_amount >= 0),
+    paid_at      timestamp(0) with time zone,
+    shipped_at   timestamp(0) with time zone,
+    cancelled_at timestamp(0) with time zone,
+    version      integer                     NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS orders_user_id_idx ON orders (user_id);
+
+CREATE TABLE IF NOT EXISTS order_lines
+(
+    id                bigserial PRIMARY KEY,
+    order_id          bigint NOT NULL REFERENCES orders ON DELETE CASCADE,
+    herb_id           bigint REFERENCES herbs ON DELETE SET NULL,
+    herb_name         text   NOT NULL,
+    quantity          bigint NOT NULL CHECK (quantity > 0),
+    unit_price_amount bigint NOT NULL CHECK (unit_price_amount >= 0),
+    line_total_amount bigint NOT NULL CHECK (line_total_amount >= 0)
+);
+
+CREATE INDEX IF NOT EXISTS order_lines_order_id_idx ON order_lines (order_id);
+
+INSERT INTO permissions (code)
+VALUES ('orders:read'),
+       ('orders:write');
\ No newline at end of file
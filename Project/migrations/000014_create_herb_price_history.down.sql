@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS herb_scheduled_prices;
+DROP TABLE IF EXISTS herb_price_history;
\ No newline at end of file
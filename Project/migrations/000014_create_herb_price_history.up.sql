@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS herb_price_history
+(
+    id                 bigserial PRIMARY KEY,
+    herb_id            bigint                      NOT NULL REFERENCES herbs ON DELETE CASCADE,
+    changed_at         timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    old_price_amount   bigint                      NOT NULL,
+    old_price_currency text                        NOT NULL REFERENCES currencies,
+    new_price_amount   bigint                      NOT NULL,
+    new_price_currency text                        NOT NULL REFERENCES currencies,
+    changed_by         bigint REFERENCES users ON DELETE SET NULL,
+    scheduled_price_id bigint
+);
+
+CREATE INDEX IF NOT EXISTS herb_price_history_herb_id_idx ON herb_price_history (herb_id, changed_at);
+
+CREATE TABLE IF NOT EXISTS herb_scheduled_prices
+(
+    id             bigserial PRIMARY KEY,
+    created_at     timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    herb_id        bigint                      NOT NULL REFERENCES herbs ON DELETE CASCADE,
+    price_amount   bigint                      NOT NULL CHECK (price_amount >= 0),
+    price_currency text                        NOT NULL REFERENCES currencies,
+    effective_at   timestamp(0) with time zone NOT NULL,
+    created_by     bigint REFERENCES users ON DELETE SET NULL,
+    applied_at     timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS herb_scheduled_prices_due_idx ON herb_scheduled_prices (effective_at) WHERE applied_at IS NULL;
\ No newline at end of file
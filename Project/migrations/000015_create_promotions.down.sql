@@ -0,0 +1,7 @@
+DELETE FROM permissions WHERE code IN ('promotions:read', 'promotions:write');
+DROP TABLE IF EXISTS promotion_redemptions;
+ALTER TABLE orders DROP COLUMN IF EXISTS coupon_code;
+ALTER TABLE orders DROP COLUMN IF EXISTS promotion_id;
+ALTER TABLE orders DROP COLUMN IF EXISTS discount_amount;
+ALTER TABLE orders DROP COLUMN IF EXISTS subtotal_amount;
+DROP TABLE IF EXISTS promotions;
\ No newline at end of file
@@ -0,0 +1,49 @@
+CREATE TABLE IF NOT EXISTS promotions
+(
+    id                  bigserial PRIMARY KEY,
+    created_at          timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    code                text UNIQUE,
+    name                text                        NOT NULL,
+    kind                text                        NOT NULL CHECK (kind IN ('percent', 'amount')),
+    percent_off         integer                     NOT NULL DEFAULT 0 CHECK (percent_off BETWEEN 0 AND 100),
+    amount_off_amount   bigint CHECK (amount_off_amount > 0),
+    amount_off_currency text REFERENCES currencies,
+    min_order_amount    bigint CHECK (min_order_amount > 0),
+    min_order_currency  text REFERENCES currencies,
+    starts_at           timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expires_at          timestamp(0) with time zone,
+    herb_ids            bigint[]                    NOT NULL DEFAULT '{}',
+    category_ids        bigint[]                    NOT NULL DEFAULT '{}',
+    culinary_uses       text[]                      NOT NULL DEFAULT '{}',
+    max_uses            integer                     NOT NULL DEFAULT 0 CHECK (max_uses >= 0),
+    max_uses_per_user   integer                     NOT NULL DEFAULT 0 CHECK (max_uses_per_user >= 0),
+    active              boolean                     NOT NULL DEFAULT true,
+    version             integer                     NOT NULL DEFAULT 1,
+    CHECK (expires_at IS NULL OR expires_at > starts_at)
+);
+
+CREATE INDEX IF NOT EXISTS promotions_sales_idx ON promotions (starts_at) WHERE code IS NULL AND active;
+
+ALTER TABLE orders ADD COLUMN subtotal_amount bigint;
+UPDATE orders SET subtotal_amount = total_amount;
+ALTER TABLE orders ALTER COLUMN subtotal_amount SET NOT NULL;
+ALTER TABLE orders ADD COLUMN discount_amount bigint NOT NULL DEFAULT 0 CHECK (discount_amount >= 0);
+ALTER TABLE orders ADD COLUMN promotion_id bigint REFERENCES promotions ON DELETE SET NULL;
+ALTER TABLE orders ADD COLUMN coupon_code text;
+
+CREATE TABLE IF NOT EXISTS promotion_redemptions
+(
+    id              bigserial PRIMARY KEY,
+    created_at      timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    promotion_id    bigint                      NOT NULL REFERENCES promotions ON DELETE CASCADE,
+    user_id         bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    order_id        bigint                      NOT NULL UNIQUE REFERENCES orders ON DELETE CASCADE,
+    discount_amount bigint                      NOT NULL,
+    currency        text                        NOT NULL REFERENCES currencies
+);
+
+CREATE INDEX IF NOT EXISTS promotion_redemptions_promotion_id_idx ON promotion_redemptions (promotion_id, user_id);
+
+INSERT INTO permissions (code)
+VALUES ('promotions:read'),
+       ('promotions:write');
\ No newline at end of file
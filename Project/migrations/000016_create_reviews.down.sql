@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code = 'reviews:moderate';
+DROP INDEX IF EXISTS herbs_average_rating_idx;
+ALTER TABLE herbs DROP COLUMN IF EXISTS review_count;
+ALTER TABLE herbs DROP COLUMN IF EXISTS average_rating;
+DROP TABLE IF EXISTS reviews;
\ No newline at end of file
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS reviews
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    herb_id    bigint                      NOT NULL REFERENCES herbs ON DELETE CASCADE,
+    user_id    bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    rating     smallint                    NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    body       text                        NOT NULL,
+    hidden     boolean                     NOT NULL DEFAULT false,
+    version    integer                     NOT NULL DEFAULT 1,
+    UNIQUE (herb_id, user_id)
+);
+
+ALTER TABLE herbs ADD COLUMN average_rating numeric(3, 2) NOT NULL DEFAULT 0;
+ALTER TABLE herbs ADD COLUMN review_count integer NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS herbs_average_rating_idx ON herbs (average_rating);
+
+INSERT INTO permissions (code)
+VALUES ('reviews:moderate');
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS herb_images;
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS herb_images
+(
+    id            bigserial PRIMARY KEY,
+    created_at    timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    herb_id       bigint                      NOT NULL REFERENCES herbs ON DELETE CASCADE,
+    position      integer                     NOT NULL,
+    image_key     text                        NOT NULL,
+    thumbnail_key text,
+    content_type  text                        NOT NULL,
+    size_bytes    bigint                      NOT NULL CHECK (size_bytes > 0)
+);
+
+CREATE INDEX IF NOT EXISTS herb_images_herb_id_idx ON herb_images (herb_id, position);
\ No newline at end of file
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS herbs_description_trgm_idx;
+DROP INDEX IF EXISTS herbs_name_trgm_idx;
+DROP INDEX IF EXISTS herbs_search_document_idx;
+ALTER TABLE herbs DROP COLUMN IF EXISTS search_document;
+DROP FUNCTION IF EXISTS herbs_search_document(text, text, text[]);
\ No newline at end of file
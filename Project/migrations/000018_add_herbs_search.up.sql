@@ -0,0 +1,22 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- array_to_string() is only marked stable, because in general it depends on the output
+-- function of the element type. For text[] it is immutable, which the generated column
+-- below needs.
+CREATE OR REPLACE FUNCTION herbs_search_document(name text, description text, culinary_uses text[])
+    RETURNS tsvector
+    LANGUAGE sql
+    IMMUTABLE PARALLEL SAFE
+AS
+$$
+SELECT setweight(to_tsvector('english', name), 'A') ||
+       setweight(to_tsvector('english', array_to_string(culinary_uses, ' ')), 'B') ||
+       setweight(to_tsvector('english', description), 'C')
+$$;
+
+ALTER TABLE herbs ADD COLUMN search_document tsvector
+    GENERATED ALWAYS AS (herbs_search_document(name, description, culinary_uses)) STORED;
+
+CREATE INDEX IF NOT EXISTS herbs_search_document_idx ON herbs USING gin (search_document);
+CREATE INDEX IF NOT EXISTS herbs_name_trgm_idx ON herbs USING gin (name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS herbs_description_trgm_idx ON herbs USING gin (description gin_trgm_ops);
\ No newline at end of file
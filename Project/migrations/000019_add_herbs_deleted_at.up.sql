@@ -0,0 +1,3 @@
+ALTER TABLE herbs ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS herbs_deleted_at_idx ON herbs (deleted_at) WHERE deleted_at IS NOT NULL;
\ No newline at end of file
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS audit_events
+(
+    id          bigserial PRIMARY KEY,
+    created_at  timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    actor_id    bigint REFERENCES users ON DELETE SET NULL,
+    action      text                        NOT NULL,
+    entity_type text                        NOT NULL,
+    entity_id   bigint                      NOT NULL,
+    changes     jsonb                       NOT NULL DEFAULT '{}',
+    request_id  text                        NOT NULL DEFAULT '',
+    ip          text                        NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_entity_idx ON audit_events (entity_type, entity_id);
+CREATE INDEX IF NOT EXISTS audit_events_actor_id_idx ON audit_events (actor_id);
+CREATE INDEX IF NOT EXISTS audit_events_created_at_idx ON audit_events (created_at);
+
+INSERT INTO permissions (code)
+VALUES ('audit:read');
\ No newline at end of file
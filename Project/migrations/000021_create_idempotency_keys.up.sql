@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys
+(
+    owner            text                        NOT NULL,
+    key              text                        NOT NULL,
+    request_hash     bytea                       NOT NULL,
+    status_code      integer,
+    response_headers jsonb,
+    response_body    bytea,
+    created_at       timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expires_at       timestamp(0) with time zone NOT NULL,
+    PRIMARY KEY (owner, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
\ No newline at end of file
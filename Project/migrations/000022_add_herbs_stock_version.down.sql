@@ -0,0 +1,2 @@
+ALTER TABLE stock_movements RENAME COLUMN stock_version TO herb_version;
+ALTER TABLE herbs DROP COLUMN IF EXISTS stock_version;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE herbs ADD COLUMN stock_version integer NOT NULL DEFAULT 1;
+ALTER TABLE stock_movements RENAME COLUMN herb_version TO stock_version;
\ No newline at end of file
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS stock_movements_variant_id_idx;
+ALTER TABLE stock_movements DROP COLUMN IF EXISTS variant_id;
+ALTER TABLE herb_variants DROP COLUMN IF EXISTS stock_version;
\ No newline at end of file
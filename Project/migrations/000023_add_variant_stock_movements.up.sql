@@ -0,0 +1,4 @@
+ALTER TABLE herb_variants ADD COLUMN stock_version integer NOT NULL DEFAULT 1;
+ALTER TABLE stock_movements ADD COLUMN variant_id bigint REFERENCES herb_variants ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS stock_movements_variant_id_idx ON stock_movements (variant_id, id);
\ No newline at end of file